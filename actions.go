@@ -1,9 +1,15 @@
 package probe
 
 import (
+	"bytes"
 	"context"
+	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/go-plugin"
@@ -12,9 +18,25 @@ import (
 )
 
 var (
-	BuiltinCmd = "builtin-actions"
-	Handshake  = plugin.HandshakeConfig{ProtocolVersion: 1, MagicCookieKey: "probe", MagicCookieValue: "actions"}
-	PluginMap  = map[string]plugin.Plugin{"actions": &ActionsPlugin{}}
+	BuiltinCmd     = "builtin-actions"
+	BuiltinActions = []string{"hello", "http", "assert", "dns", "file", "smtp", "tcp", "websocket"}
+	Handshake      = plugin.HandshakeConfig{ProtocolVersion: 1, MagicCookieKey: "probe", MagicCookieValue: "actions"}
+	PluginMap      = map[string]plugin.Plugin{"actions": &ActionsPlugin{}}
+
+	// ActionStartTimeout is the time to wait for an action plugin to start
+	ActionStartTimeout = 10 * time.Second
+
+	// BuiltinActionDescriptions are shown by the list of actions
+	BuiltinActionDescriptions = map[string]string{
+		"hello":     "Return a greeting, for trying workflows",
+		"http":      "Send an http request",
+		"assert":    "Evaluate expressions against the given params",
+		"dns":       "Resolve dns records",
+		"file":      "Check existence, stat, content and checksum of a file",
+		"smtp":      "Send mails over smtp",
+		"tcp":       "Connect to a tcp or udp port and read the banner",
+		"websocket": "Exchange messages over websocket",
+	}
 )
 
 type ActionsArgs []string
@@ -43,8 +65,13 @@ type ActionsClient struct {
 }
 
 func (m *ActionsClient) Run(args []string, with map[string]string) (map[string]string, error) {
+	return m.RunContext(context.Background(), args, with)
+}
+
+// RunContext runs the action, and cancels the call when the context is done.
+func (m *ActionsClient) RunContext(ctx context.Context, args []string, with map[string]string) (map[string]string, error) {
 	res := map[string]string{}
-	runRes, err := m.client.Run(context.Background(), &pb.RunRequest{
+	runRes, err := m.client.Run(ctx, &pb.RunRequest{
 		Args: args,
 		With: with,
 	})
@@ -65,7 +92,73 @@ func (m *ActionsServer) Run(ctx context.Context, req *pb.RunRequest) (*pb.RunRes
 	return &pb.RunResponse{Result: v}, err
 }
 
-func RunActions(name string, args []string, with map[string]any, verbose bool) (map[string]any, error) {
+// builtinActionParams are the params of `with` the builtin actions require, checked by the dry run.
+var builtinActionParams = map[string][]string{
+	"http":      {"url"},
+	"assert":    {"expr"},
+	"dns":       {"name"},
+	"file":      {"path"},
+	"smtp":      {"addr", "from", "to"},
+	"tcp":       {"host", "port"},
+	"websocket": {"url"},
+}
+
+// missingActionParams returns the required params of the builtin action that are not in with.
+func missingActionParams(name string, with map[string]any) []string {
+	var missing []string
+	for _, p := range builtinActionParams[name] {
+		if v, ok := with[p]; !ok || v == nil || v == "" {
+			missing = append(missing, p)
+		}
+	}
+	return missing
+}
+
+func IsBuiltinAction(name string) bool {
+	for _, a := range BuiltinActions {
+		if a == name {
+			return true
+		}
+	}
+	return false
+}
+
+// RunActions runs the action plugin, and kills it when the context is done before the action returns.
+func RunActions(ctx context.Context, name string, args []string, with map[string]any, verbose bool) (map[string]any, error) {
+	cl, actions, err := startActions(name, verbose, os.Stdout)
+	if cl != nil {
+		defer cl.Kill()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	type runResult struct {
+		result map[string]string
+		err    error
+	}
+	ch := make(chan runResult, 1)
+
+	flatW := FlattenInterface(with)
+	go func() {
+		result, err := actions.Run(args, flatW)
+		ch <- runResult{result: result, err: err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case r := <-ch:
+		if r.err != nil {
+			return nil, r.err
+		}
+		return UnflattenInterface(r.result), nil
+	}
+}
+
+// startActions starts the action plugin, of which logs are written to out. The client is returned
+// with an error too, to be killed.
+func startActions(name string, verbose bool, out io.Writer) (*plugin.Client, *ActionsClient, error) {
 	loglevel := hclog.Warn
 	if verbose {
 		loglevel = hclog.Debug
@@ -73,37 +166,161 @@ func RunActions(name string, args []string, with map[string]any, verbose bool) (
 
 	log := hclog.New(&hclog.LoggerOptions{
 		Name:   "actions",
-		Output: os.Stdout,
+		Output: out,
 		Level:  loglevel,
 	})
 
+	stderr := &stderrBuffer{}
 	cl := plugin.NewClient(&plugin.ClientConfig{
 		HandshakeConfig:  Handshake,
 		Plugins:          PluginMap,
 		Cmd:              exec.Command(os.Args[0], BuiltinCmd, name),
 		AllowedProtocols: []plugin.Protocol{plugin.ProtocolNetRPC, plugin.ProtocolGRPC},
 		Logger:           log,
+		StartTimeout:     ActionStartTimeout,
+		Stderr:           stderr,
 	})
-	defer cl.Kill()
 
 	protocol, err := cl.Client()
 	if err != nil {
-		return nil, err
+		if out := stderr.String(); out != "" {
+			return cl, nil, fmt.Errorf("action '%s' failed to start: %w\nstderr:\n%s", name, err, out)
+		}
+		return cl, nil, fmt.Errorf("action '%s' failed to start: %w", name, err)
 	}
 
 	raw, err := protocol.Dispense("actions")
 	if err != nil {
-		return nil, err
+		return cl, nil, err
 	}
 
-	actions := raw.(Actions)
+	return cl, raw.(*ActionsClient), nil
+}
 
-	flatW := FlattenInterface(with)
-	result, err := actions.Run(args, flatW)
+// ActionClients starts each action plugin once, and shares it among the steps of a workflow run,
+// instead of starting a plugin process for every step. Close kills the plugins at the end of the run.
+type ActionClients struct {
+	mu      sync.Mutex
+	clients map[string]*actionClient
+	log     io.Writer
+}
+
+type actionClient struct {
+	once    sync.Once
+	cl      *plugin.Client
+	actions *ActionsClient
+	err     error
+	// calls is the number of running calls, and retired is set when the plugin is no longer used
+	// by the next calls. Both are guarded by the mutex of ActionClients.
+	calls   int
+	retired bool
+}
+
+// NewActionClients returns the clients, of which plugins write logs to log, such as the masked console output.
+func NewActionClients(log io.Writer) *ActionClients {
+	return &ActionClients{clients: map[string]*actionClient{}, log: log}
+}
+
+// Run runs the action like RunActions, with the plugin started by the first step using the action.
+// A plugin that failed to start or has exited is started again by the next step.
+// When the context is done before the action returns, the plugin is retired, because the action
+// keeps running in it otherwise. The next step starts a new one, and the retired one is killed
+// after the other steps running on it finish.
+func (c *ActionClients) Run(ctx context.Context, name string, args []string, with map[string]any, verbose bool) (map[string]any, error) {
+	ac := c.start(name, verbose)
+	if ac.err == nil && ac.cl.Exited() {
+		c.release(name, ac, true)
+		ac = c.start(name, verbose)
+	}
+	if ac.err != nil {
+		c.release(name, ac, true)
+		return nil, ac.err
+	}
+
+	result, err := ac.actions.RunContext(ctx, args, FlattenInterface(with))
+	ctxErr := ctx.Err()
+	c.release(name, ac, ctxErr != nil || (err != nil && ac.cl.Exited()))
+	if ctxErr != nil {
+		return nil, ctxErr
+	}
 	if err != nil {
 		return nil, err
 	}
-	unflatR := UnflattenInterface(result)
 
-	return unflatR, nil
+	return UnflattenInterface(result), nil
+}
+
+// start returns the client of the action, and starts the plugin when it is the first call.
+func (c *ActionClients) start(name string, verbose bool) *actionClient {
+	c.mu.Lock()
+	ac, ok := c.clients[name]
+	if !ok {
+		ac = &actionClient{}
+		c.clients[name] = ac
+	}
+	ac.calls++
+	c.mu.Unlock()
+
+	// plugins of other actions start in parallel
+	ac.once.Do(func() {
+		ac.cl, ac.actions, ac.err = startActions(name, verbose, c.log)
+	})
+	return ac
+}
+
+// release ends a call started by start. With retire, the next calls start a new plugin.
+// The retired plugin is killed when no call is running on it, so that a canceled step
+// does not stop the steps of other jobs.
+func (c *ActionClients) release(name string, ac *actionClient, retire bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ac.calls--
+	if retire {
+		ac.retired = true
+		if c.clients[name] == ac {
+			delete(c.clients, name)
+		}
+	}
+	if ac.retired && ac.calls == 0 && ac.cl != nil {
+		ac.cl.Kill()
+	}
+}
+
+// Close kills all plugins.
+func (c *ActionClients) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for name, ac := range c.clients {
+		if ac.cl != nil {
+			ac.cl.Kill()
+		}
+		delete(c.clients, name)
+	}
+}
+
+// stderrMax is the size of the stderr of a plugin kept for the error
+const stderrMax = 4096
+
+// stderrBuffer keeps the tail of the stderr of a plugin, written by the goroutine of the plugin client.
+type stderrBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *stderrBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf.Write(p)
+	if over := b.buf.Len() - stderrMax; over > 0 {
+		b.buf.Next(over)
+	}
+	return len(p), nil
+}
+
+func (b *stderrBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return strings.TrimSpace(b.buf.String())
 }
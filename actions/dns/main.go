@@ -0,0 +1,50 @@
+package dns
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/go-plugin"
+	"github.com/linyows/probe"
+	"github.com/linyows/probe/dns"
+)
+
+type Action struct {
+	log hclog.Logger
+}
+
+func (a *Action) Run(args []string, with map[string]string) (map[string]string, error) {
+	a.log.Debug(fmt.Sprintf("received: %#v", with))
+
+	before := dns.WithBefore(func(name, typ, server string) {
+		a.log.Debug(fmt.Sprintf("dns.Lookup: %s %s %s", name, typ, server))
+	})
+	after := dns.WithAfter(func(res *dns.Res) {
+		a.log.Debug(fmt.Sprintf("dns.Res: %#v", res))
+	})
+	ret, err := dns.Request(with, before, after)
+
+	a.log.Debug(fmt.Sprintf("return: %#v", ret))
+	a.log.Debug(fmt.Sprintf("error: %#v", err))
+
+	return ret, err
+}
+
+func Serve() {
+	log := hclog.New(&hclog.LoggerOptions{
+		Level:      hclog.Debug,
+		Output:     os.Stderr,
+		JSONFormat: true,
+	})
+
+	pl := &probe.ActionsPlugin{
+		Impl: &Action{log: log},
+	}
+
+	plugin.Serve(&plugin.ServeConfig{
+		HandshakeConfig: probe.Handshake,
+		Plugins:         map[string]plugin.Plugin{"actions": pl},
+		GRPCServer:      plugin.DefaultGRPCServer,
+	})
+}
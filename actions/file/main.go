@@ -0,0 +1,50 @@
+package file
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/go-plugin"
+	"github.com/linyows/probe"
+	"github.com/linyows/probe/file"
+)
+
+type Action struct {
+	log hclog.Logger
+}
+
+func (a *Action) Run(args []string, with map[string]string) (map[string]string, error) {
+	a.log.Debug(fmt.Sprintf("received: %#v", with))
+
+	before := file.WithBefore(func(op, path string) {
+		a.log.Debug(fmt.Sprintf("file.%s: %s", op, path))
+	})
+	after := file.WithAfter(func(res *file.Res) {
+		a.log.Debug(fmt.Sprintf("file.Res: %#v", res))
+	})
+	ret, err := file.Request(with, before, after)
+
+	a.log.Debug(fmt.Sprintf("return: %#v", ret))
+	a.log.Debug(fmt.Sprintf("error: %#v", err))
+
+	return ret, err
+}
+
+func Serve() {
+	log := hclog.New(&hclog.LoggerOptions{
+		Level:      hclog.Debug,
+		Output:     os.Stderr,
+		JSONFormat: true,
+	})
+
+	pl := &probe.ActionsPlugin{
+		Impl: &Action{log: log},
+	}
+
+	plugin.Serve(&plugin.ServeConfig{
+		HandshakeConfig: probe.Handshake,
+		Plugins:         map[string]plugin.Plugin{"actions": pl},
+		GRPCServer:      plugin.DefaultGRPCServer,
+	})
+}
@@ -8,7 +8,6 @@ import (
 	"net/url"
 	"os"
 	"path"
-	"strconv"
 	"strings"
 
 	"github.com/hashicorp/go-hclog"
@@ -132,8 +131,8 @@ func convertBodyToJson(data map[string]string) error {
 		if strings.HasPrefix(key, "body__") {
 			newKey := strings.TrimPrefix(key, "body__")
 			// If it is a numeric string, set the type to number
-			num, err := strconv.Atoi(value)
-			if err == nil {
+			num, ok := probe.StrictAtoi(value)
+			if ok {
 				values[newKey] = num
 			} else {
 				values[newKey] = value
@@ -0,0 +1,51 @@
+package websocket
+
+import (
+	"fmt"
+	hp "net/http"
+	"os"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/go-plugin"
+	"github.com/linyows/probe"
+	"github.com/linyows/probe/websocket"
+)
+
+type Action struct {
+	log hclog.Logger
+}
+
+func (a *Action) Run(args []string, with map[string]string) (map[string]string, error) {
+	a.log.Debug(fmt.Sprintf("received: %#v", with))
+
+	before := websocket.WithBefore(func(url string, header hp.Header) {
+		a.log.Debug(fmt.Sprintf("websocket.Dial: %s %#v", url, header))
+	})
+	after := websocket.WithAfter(func(res *websocket.Res) {
+		a.log.Debug(fmt.Sprintf("websocket.Res: %#v", res))
+	})
+	ret, err := websocket.Request(with, before, after)
+
+	a.log.Debug(fmt.Sprintf("return: %#v", ret))
+	a.log.Debug(fmt.Sprintf("error: %#v", err))
+
+	return ret, err
+}
+
+func Serve() {
+	log := hclog.New(&hclog.LoggerOptions{
+		Level:      hclog.Debug,
+		Output:     os.Stderr,
+		JSONFormat: true,
+	})
+
+	pl := &probe.ActionsPlugin{
+		Impl: &Action{log: log},
+	}
+
+	plugin.Serve(&plugin.ServeConfig{
+		HandshakeConfig: probe.Handshake,
+		Plugins:         map[string]plugin.Plugin{"actions": pl},
+		GRPCServer:      plugin.DefaultGRPCServer,
+	})
+}
@@ -0,0 +1,165 @@
+package probe
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/go-plugin"
+)
+
+// TestMain lets the test binary act as action plugins, since RunActions runs os.Args[0] as plugins.
+// The pid action returns the process id of the plugin, and logs params like actions. Others are broken.
+func TestMain(m *testing.M) {
+	if len(os.Args) >= 3 && os.Args[1] == BuiltinCmd {
+		if os.Args[2] == "pid" {
+			log := hclog.New(&hclog.LoggerOptions{Level: hclog.Debug, Output: os.Stderr, JSONFormat: true})
+			plugin.Serve(&plugin.ServeConfig{
+				HandshakeConfig: Handshake,
+				Plugins:         map[string]plugin.Plugin{"actions": &ActionsPlugin{Impl: pidAction{log: log}}},
+				GRPCServer:      plugin.DefaultGRPCServer,
+			})
+			os.Exit(0)
+		}
+		fmt.Fprintln(os.Stderr, "panic: cannot open config")
+		time.Sleep(time.Minute)
+		os.Exit(2)
+	}
+	os.Exit(m.Run())
+}
+
+type pidAction struct {
+	log hclog.Logger
+}
+
+func (a pidAction) Run(args []string, with map[string]string) (map[string]string, error) {
+	a.log.Debug(fmt.Sprintf("received: %#v", with))
+	if d, err := time.ParseDuration(with["sleep"]); err == nil {
+		time.Sleep(d)
+	}
+	return map[string]string{"pid": strconv.Itoa(os.Getpid())}, nil
+}
+
+func TestActionClients(t *testing.T) {
+	clients := NewActionClients(io.Discard)
+	defer clients.Close()
+
+	var wg sync.WaitGroup
+	pids := make([]any, 5)
+	for i := range pids {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got, err := clients.Run(context.Background(), "pid", []string{}, map[string]any{}, false)
+			if err != nil {
+				t.Errorf("got error %s", err)
+				return
+			}
+			pids[i] = got["pid"]
+		}()
+	}
+	wg.Wait()
+
+	for _, pid := range pids[1:] {
+		if pid != pids[0] {
+			t.Fatalf("expected one plugin shared, got pids %v", pids)
+		}
+	}
+
+	// the plugin running the timed out action is killed, and replaced by a new one
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := clients.Run(ctx, "pid", []string{}, map[string]any{"sleep": "1s"}, false); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+	got, err := clients.Run(context.Background(), "pid", []string{}, map[string]any{}, false)
+	if err != nil || got["pid"] == pids[0] {
+		t.Errorf("expected a new plugin after the timeout, got %v (%v)", got["pid"], err)
+	}
+
+	// killed plugins are started again
+	replaced := got["pid"]
+	clients.Close()
+	got, err = clients.Run(context.Background(), "pid", []string{}, map[string]any{}, false)
+	if err != nil || got["pid"] == replaced {
+		t.Errorf("expected a new plugin after close, got %v (%v)", got["pid"], err)
+	}
+}
+
+func TestActionClientsCanceledStep(t *testing.T) {
+	// the plugin is shared by the jobs running at the same time, and a step of one job times out
+	wf := &Workflow{
+		Name: "Test",
+		Jobs: []Job{
+			{Name: "Slow", Steps: []*Step{{Name: "Sleep", Uses: "pid", With: map[string]any{"sleep": "500ms"}}}},
+			{Name: "Timeout", Steps: []*Step{{Name: "Sleep", Uses: "pid", With: map[string]any{"sleep": "2s"}, Timeout: 100 * time.Millisecond}}},
+		},
+		env: map[string]string{},
+	}
+	if err := wf.Start(Config{Log: &bytes.Buffer{}}); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	statuses := map[string]string{}
+	for _, j := range wf.Result().Jobs {
+		statuses[j.Name] = j.Steps[0].Status + ": " + j.Steps[0].Message
+	}
+	expected := map[string]string{
+		"Slow":    StatusNoTest + ": ",
+		"Timeout": StatusError + ": action 'pid' timed out after 100ms",
+	}
+	if !reflect.DeepEqual(statuses, expected) {
+		t.Errorf("expected the other job not to fail, got %v", statuses)
+	}
+}
+
+func TestActionClientsMaskedLog(t *testing.T) {
+	wf := &Workflow{
+		Name: "Test",
+		Vars: map[string]any{"api_token": "s3cr3t-value"},
+		Jobs: []Job{{Name: "Job", Steps: []*Step{{Name: "Pid", Uses: "pid", With: map[string]any{"token": "{vars.api_token}"}}}}},
+		env:  map[string]string{},
+	}
+	var buf bytes.Buffer
+	if err := wf.Start(Config{Log: &buf, Verbose: true}); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	out := buf.String()
+	if !strings.Contains(out, "received:") {
+		t.Fatalf("expected the log of the plugin in the output, got:\n%s", out)
+	}
+	if strings.Contains(out, "s3cr3t-value") {
+		t.Errorf("expected the secret to be masked in the log of the plugin, got:\n%s", out)
+	}
+}
+
+func TestRunActionsStartTimeout(t *testing.T) {
+	orig := ActionStartTimeout
+	ActionStartTimeout = 500 * time.Millisecond
+	t.Cleanup(func() { ActionStartTimeout = orig })
+
+	start := time.Now()
+	_, err := RunActions(context.Background(), "broken", []string{}, map[string]any{}, false)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected to fail fast, but took %s", elapsed)
+	}
+	for _, expected := range []string{"action 'broken' failed to start", "panic: cannot open config"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected %q in error: %s", expected, err)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package assert
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/linyows/probe"
+)
+
+type Req struct {
+	Exprs map[string]string `map:"expr" validate:"required"`
+	Env   map[string]any
+	cb    *Callback
+}
+
+type Res struct {
+	Passed  bool     `map:"passed"`
+	Results []bool   `map:"results"`
+	Failed  []string `map:"failed"`
+}
+
+type Result struct {
+	Req Req `map:"req"`
+	Res Res `map:"res"`
+}
+
+func NewReq() *Req {
+	return &Req{
+		Exprs: map[string]string{},
+		Env:   map[string]any{},
+	}
+}
+
+// Do evaluates each expression against the other params of the step, and passes when all of them are true.
+func (r *Req) Do() (*Result, error) {
+	if len(r.Exprs) == 0 {
+		return nil, errors.New("Req.Exprs is required")
+	}
+
+	// callback
+	if r.cb != nil && r.cb.before != nil {
+		r.cb.before(r.Exprs, r.Env)
+	}
+
+	result := &Result{
+		Req: *r,
+		Res: Res{Passed: true, Results: []bool{}, Failed: []string{}},
+	}
+
+	expr := &probe.Expr{}
+	for i, code := range r.sortedExprs() {
+		out, err := expr.Eval(code, r.Env)
+		if err != nil {
+			return nil, fmt.Errorf("expr[%d]: %w", i, err)
+		}
+		ok, isBool := out.(bool)
+		if !isBool {
+			return nil, fmt.Errorf("expr[%d] must return bool, but got %T: %s", i, out, code)
+		}
+		result.Res.Results = append(result.Res.Results, ok)
+		if !ok {
+			result.Res.Passed = false
+			result.Res.Failed = append(result.Res.Failed, code)
+		}
+	}
+
+	// callback
+	if r.cb != nil && r.cb.after != nil {
+		r.cb.after(&result.Res)
+	}
+
+	return result, nil
+}
+
+// sortedExprs returns expressions ordered by the numeric index of `expr__<index>`.
+func (r *Req) sortedExprs() []string {
+	keys := make([]string, 0, len(r.Exprs))
+	for k := range r.Exprs {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, errA := strconv.Atoi(keys[i])
+		b, errB := strconv.Atoi(keys[j])
+		if errA != nil || errB != nil {
+			return keys[i] < keys[j]
+		}
+		return a < b
+	})
+
+	exprs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		exprs = append(exprs, r.Exprs[k])
+	}
+	return exprs
+}
+
+type Option func(*Callback)
+
+type Callback struct {
+	before func(exprs map[string]string, env map[string]any)
+	after  func(res *Res)
+}
+
+// Request evaluates `expr__<index>` params. The other params are the variables of the expressions.
+func Request(data map[string]string, opts ...Option) (map[string]string, error) {
+	m := probe.UnflattenInterface(data, "expr")
+	r := NewReq()
+
+	cb := &Callback{}
+	for _, opt := range opts {
+		opt(cb)
+	}
+	r.cb = cb
+
+	if err := probe.MapToStructByTags(m, r); err != nil {
+		return map[string]string{}, err
+	}
+	for k, v := range m {
+		if k != "expr" {
+			r.Env[k] = v
+		}
+	}
+
+	ret, err := r.Do()
+	if err != nil {
+		return map[string]string{}, err
+	}
+
+	mapRet, err := probe.StructToMapByTags(ret)
+	if err != nil {
+		return map[string]string{}, err
+	}
+
+	return probe.FlattenInterface(mapRet), nil
+}
+
+func WithBefore(f func(exprs map[string]string, env map[string]any)) Option {
+	return func(c *Callback) {
+		c.before = f
+	}
+}
+
+func WithAfter(f func(res *Res)) Option {
+	return func(c *Callback) {
+		c.after = f
+	}
+}
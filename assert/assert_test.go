@@ -0,0 +1,51 @@
+package assert
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDo(t *testing.T) {
+	req := NewReq()
+	req.Exprs = map[string]string{
+		"0":  "a == b",
+		"1":  "code >= 200 && code < 300",
+		"10": "a != b",
+	}
+	req.Env = map[string]any{"a": "token", "b": "token", "code": 201}
+
+	got, err := req.Do()
+	if err != nil {
+		t.Fatalf("got error %s", err)
+	}
+	expected := Res{Passed: false, Results: []bool{true, true, false}, Failed: []string{"a != b"}}
+	if !reflect.DeepEqual(got.Res, expected) {
+		t.Errorf("expected %#v, got %#v", expected, got.Res)
+	}
+}
+
+func TestDoNotBool(t *testing.T) {
+	req := NewReq()
+	req.Exprs = map[string]string{"0": "a + 1"}
+	req.Env = map[string]any{"a": 1}
+
+	if _, err := req.Do(); err == nil || err.Error() != "expr[0] must return bool, but got int: a + 1" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestRequest(t *testing.T) {
+	got, err := Request(map[string]string{
+		"expr__0":  "actual == expected",
+		"expr__1":  "count > 3",
+		"actual":   "ok",
+		"expected": "ok",
+		"count":    "5",
+	})
+	if err != nil {
+		t.Fatalf("got error %s", err)
+	}
+	if got["res__passed"] != "true" || got["res__results__0"] != "true" || got["res__results__1"] != "true" {
+		t.Errorf("unexpected result %#v", got)
+	}
+}
@@ -1,14 +1,24 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"os"
+	"sort"
 	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/linyows/probe"
+	"github.com/linyows/probe/actions/assert"
+	"github.com/linyows/probe/actions/dns"
+	"github.com/linyows/probe/actions/file"
 	"github.com/linyows/probe/actions/hello"
 	http "github.com/linyows/probe/actions/http"
 	"github.com/linyows/probe/actions/smtp"
+	"github.com/linyows/probe/actions/tcp"
+	"github.com/linyows/probe/actions/websocket"
 )
 
 type Cmd struct {
@@ -17,6 +27,31 @@ type Cmd struct {
 	Lint         bool
 	Help         bool
 	Verbose      bool
+	DryRun       bool
+	NoColor      bool
+	Timeline     bool
+	Compact      bool
+	FailFast     bool
+	Profile      string
+	Timeout      time.Duration
+	RerunFailed  string
+	Watch        bool
+	ListActions  bool
+	OutputFormat string
+	Output       string
+	ForceColor   bool
+	LogFormat    string
+	SummaryPath  string
+	DumpDir      string
+	VarsFiles    []string
+	EnvFiles     []string
+	ExportEnv    bool
+	Concurrency  int
+	MetricsAddr  string
+	PushGateway  string
+	Schedule     string
+	Overlap      string
+	metrics      *probe.Metrics
 	validFlags   []string
 	ver          string
 	rev          string
@@ -28,8 +63,18 @@ func runBuiltinActions(name string) {
 		http.Serve()
 	case "hello":
 		hello.Serve()
+	case "assert":
+		assert.Serve()
+	case "dns":
+		dns.Serve()
+	case "file":
+		file.Serve()
 	case "smtp":
 		smtp.Serve()
+	case "tcp":
+		tcp.Serve()
+	case "websocket":
+		websocket.Serve()
 	}
 }
 
@@ -40,7 +85,7 @@ func newCmd(args []string) *Cmd {
 	}
 
 	c := Cmd{
-		validFlags: []string{"help", "init", "lint", "workflow", "verbose"},
+		validFlags: []string{"help", "init", "lint", "workflow", "verbose", "dry-run", "output-format", "output", "force-color", "log-format", "summary", "dump-dir", "vars-file", "env-file", "export-env", "profile", "max-concurrency", "metrics-addr", "pushgateway", "no-color", "timeline", "compact", "fail-fast", "timeout", "rerun-failed", "watch", "schedule", "schedule-overlap", "list-actions"},
 		ver:        version,
 		rev:        commit,
 	}
@@ -50,6 +95,36 @@ func newCmd(args []string) *Cmd {
 	flag.BoolVar(&c.Init, "init", false, "Export a workflow template as yaml file")
 	flag.BoolVar(&c.Lint, "lint", false, "Check the syntax in workflow")
 	flag.BoolVar(&c.Verbose, "verbose", false, "Show verbose log")
+	flag.StringVar(&c.OutputFormat, "output-format", "", "Specify the result format: json or junit")
+	flag.StringVar(&c.Output, "output", "", "Write the report to the file instead of stdout (- is stdout)")
+	flag.StringVar(&c.LogFormat, "log-format", "", "Specify the log format: text or json lines of events")
+	flag.StringVar(&c.SummaryPath, "summary", "", "Export step timing summary to a csv or json file")
+	flag.StringVar(&c.DumpDir, "dump-dir", "", "Write the full request and response of each step to files in the dir")
+	flag.Func("vars-file", "Load vars from a yaml, json or dotenv file (repeatable)", func(s string) error {
+		c.VarsFiles = append(c.VarsFiles, s)
+		return nil
+	})
+	flag.Func("env-file", "Load environment variables from a dotenv file, not overriding the environment (repeatable)", func(s string) error {
+		c.EnvFiles = append(c.EnvFiles, s)
+		return nil
+	})
+	flag.BoolVar(&c.ExportEnv, "export-env", false, "Set variables of env files to the environment of actions")
+	flag.StringVar(&c.Profile, "profile", "", "Select the profile of vars in the workflow, like staging")
+	flag.IntVar(&c.Concurrency, "max-concurrency", 0, "Limit the number of jobs running at the same time (0 is unlimited)")
+	flag.StringVar(&c.MetricsAddr, "metrics-addr", "", "Serve prometheus metrics of steps at /metrics of the address, like :9090")
+	flag.StringVar(&c.PushGateway, "pushgateway", "", "Push prometheus metrics of steps to the pushgateway url after each run")
+	flag.BoolVar(&c.NoColor, "no-color", false, "Disable colored output")
+	flag.BoolVar(&c.ForceColor, "force-color", false, "Color the output even when it is not a terminal")
+	flag.BoolVar(&c.Timeline, "timeline", false, "Show when each step started and how long it took")
+	flag.BoolVar(&c.Compact, "compact", false, "Show a line per step with the response time, and the summary of steps")
+	flag.BoolVar(&c.FailFast, "fail-fast", false, "Abort the running and remaining jobs as soon as a job fails")
+	flag.DurationVar(&c.Timeout, "timeout", 0, "Abort the run when it takes longer than the duration, like 5m (0 is unlimited)")
+	flag.StringVar(&c.RerunFailed, "rerun-failed", "", "Run only jobs failed in the previous run of which the result is in the json file")
+	flag.BoolVar(&c.Watch, "watch", false, "Run the workflow again when the workflow or vars files change")
+	flag.StringVar(&c.Schedule, "schedule", "", "Run the workflow repeatedly by an interval like 30s or a cron spec like \"*/5 * * * *\"")
+	flag.StringVar(&c.Overlap, "schedule-overlap", OverlapSkip, "Skip or queue a scheduled run while the previous run is running: skip or queue (a queued run starts right after the previous run)")
+	flag.BoolVar(&c.ListActions, "list-actions", false, "Show available actions")
+	flag.BoolVar(&c.DryRun, "dry-run", false, "Validate the workflow and show what would run without executing actions")
 
 	for _, arg := range args[1:] {
 		if strings.HasPrefix(arg, "-") && !c.isValid(arg) {
@@ -82,25 +157,123 @@ func (c *Cmd) usage() {
 Probe - scenario testing tool (ver: %s [%s])
 
 Usage: probe [options] <command>
+
+Exit codes:
+  0  all jobs passed
+  1  a test failed
+  2  an action failed to run
+  3  the workflow or options are invalid
+  4  the run timed out
 `
 	h = strings.TrimPrefix(h, "\n")
 	fmt.Fprint(flag.CommandLine.Output(), fmt.Sprintf(h, c.ver, c.rev))
 }
 
+func (c *Cmd) listActions() {
+	names := append([]string{}, probe.BuiltinActions...)
+	sort.Strings(names)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tTYPE\tDESCRIPTION")
+	for _, name := range names {
+		fmt.Fprintf(w, "%s\tbuiltin\t%s\n", name, probe.BuiltinActionDescriptions[name])
+	}
+	w.Flush()
+}
+
+func (c *Cmd) options() []probe.Option {
+	return []probe.Option{
+		probe.WithDryRun(c.DryRun),
+		probe.WithNoColor(c.NoColor),
+		probe.WithForceColor(c.ForceColor),
+		probe.WithTimeline(c.Timeline),
+		probe.WithCompact(c.Compact),
+		probe.WithFailFast(c.FailFast),
+		probe.WithTimeout(c.Timeout),
+		probe.WithRerunFailed(c.RerunFailed),
+		probe.WithOutputFormat(c.OutputFormat),
+		probe.WithLogFormat(c.LogFormat),
+		probe.WithSummaryPath(c.SummaryPath),
+		probe.WithDumpDir(c.DumpDir),
+		probe.WithVarsFiles(c.VarsFiles),
+		probe.WithEnvFiles(c.EnvFiles),
+		probe.WithExportEnv(c.ExportEnv),
+		probe.WithProfile(c.Profile),
+		probe.WithMaxConcurrency(c.Concurrency),
+		probe.WithMetrics(c.metrics),
+	}
+}
+
+// run runs the workflow once, writing the report to the output file if any.
+func (c *Cmd) run() (*probe.Probe, error) {
+	opts := c.options()
+	if c.Output != "" && c.Output != "-" {
+		f, err := os.Create(c.Output)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		opts = append(opts, probe.WithLog(f))
+	}
+
+	p := probe.New(c.WorkflowPath, c.Verbose, opts...)
+	err := p.Do()
+	c.pushMetrics()
+
+	return p, err
+}
+
 func (c *Cmd) start() int {
 	switch {
 	case c.Help:
 		c.usage()
+	case c.ListActions:
+		c.listActions()
+		return 0
 	case c.Lint:
 	case c.Init:
+	case c.Watch:
+		if err := c.setupMetrics(); err != nil {
+			fmt.Printf("%s\n", err)
+			return probe.ExitConfigError
+		}
+		return c.watch()
+	case c.Schedule != "":
+		if err := c.setupMetrics(); err != nil {
+			fmt.Printf("%s\n", err)
+			return probe.ExitConfigError
+		}
+		return c.schedule()
 	default:
-		p := probe.New(c.WorkflowPath, c.Verbose)
-		if err := p.Do(); err != nil {
-			fmt.Printf("%#v\n", err)
-		} else {
-			return p.ExitStatus()
+		if err := c.setupMetrics(); err != nil {
+			fmt.Printf("%s\n", err)
+			return probe.ExitConfigError
+		}
+		p, err := c.run()
+		if err != nil {
+			fmt.Printf("%s\n", err)
 		}
+		return exitStatus(p, err)
 	}
 
 	return 1
 }
+
+// exitStatus returns the exit status of the run, or of the error category when the run failed.
+func exitStatus(p *probe.Probe, err error) int {
+	if err == nil {
+		return p.ExitStatus()
+	}
+	var ce *probe.ConfigError
+	var ae *probe.ActionError
+	var te *probe.TimeoutError
+	switch {
+	case errors.As(err, &ce):
+		return probe.ExitConfigError
+	case errors.As(err, &ae):
+		return probe.ExitActionError
+	case errors.As(err, &te):
+		return probe.ExitTimeout
+	}
+	return 1
+}
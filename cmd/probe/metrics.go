@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/linyows/probe"
+)
+
+// setupMetrics starts aggregating results of steps when the metrics endpoint or the pushgateway is given,
+// and serves them at /metrics of the address.
+func (c *Cmd) setupMetrics() error {
+	if c.MetricsAddr == "" && c.PushGateway == "" {
+		return nil
+	}
+	c.metrics = probe.NewMetrics()
+	if c.MetricsAddr == "" {
+		return nil
+	}
+
+	l, err := net.Listen("tcp", c.MetricsAddr)
+	if err != nil {
+		return fmt.Errorf("metrics: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", c.metrics)
+	go func() {
+		_ = http.Serve(l, mux)
+	}()
+
+	return nil
+}
+
+// pushMetrics pushes the metrics to the pushgateway after a run.
+func (c *Cmd) pushMetrics() {
+	if c.PushGateway == "" || c.metrics == nil {
+		return
+	}
+	if err := c.metrics.Push(c.PushGateway); err != nil {
+		fmt.Printf("pushgateway error: %s\n", err)
+	}
+}
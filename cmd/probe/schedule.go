@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/linyows/probe"
+	"github.com/robfig/cron/v3"
+)
+
+const (
+	OverlapSkip  = "skip"
+	OverlapQueue = "queue"
+)
+
+// parseSchedule returns the function of the next run time, from an interval like `30s` or a cron spec like `*/5 * * * *`.
+func parseSchedule(spec string) (func(time.Time) time.Time, error) {
+	if d, err := time.ParseDuration(spec); err == nil {
+		if d <= 0 {
+			return nil, fmt.Errorf("schedule interval must be positive: %s", spec)
+		}
+		return func(t time.Time) time.Time { return t.Add(d) }, nil
+	}
+
+	s, err := cron.ParseStandard(spec)
+	if err != nil {
+		return nil, fmt.Errorf("schedule must be an interval or a cron spec: %w", err)
+	}
+	return s.Next, nil
+}
+
+// schedule runs the workflow at start and on every tick of the schedule, until SIGINT or SIGTERM.
+// A tick while the previous run is still running is skipped, or queued to run after it with the queue overlap.
+// At most one run is queued, and it starts as soon as the previous run finishes, so that a run longer than
+// the interval is followed by a catch-up run without waiting. Ticks are not shifted by the catch-up run,
+// and the ticks while it is queued are skipped. The running one finishes before the process exits.
+func (c *Cmd) schedule() int {
+	next, err := parseSchedule(c.Schedule)
+	if err != nil {
+		fmt.Printf("%s\n", err)
+		return probe.ExitConfigError
+	}
+	var runs chan struct{}
+	switch c.Overlap {
+	case "", OverlapSkip:
+		runs = make(chan struct{})
+	case OverlapQueue:
+		runs = make(chan struct{}, 1)
+	default:
+		fmt.Printf("unknown schedule overlap '%s'\n", c.Overlap)
+		return probe.ExitConfigError
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range runs {
+			c.scheduledRun()
+		}
+	}()
+
+	runs <- struct{}{}
+	tick := time.Now()
+	for {
+		tick = next(tick)
+		timer := time.NewTimer(time.Until(tick))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			fmt.Printf("schedule: shutting down after the running workflow\n")
+			close(runs)
+			<-done
+			return 0
+		case <-timer.C:
+			select {
+			case runs <- struct{}{}:
+			default:
+				fmt.Printf("schedule: skipped the run at %s, because the previous run is still running\n", tick.Format(time.RFC3339))
+			}
+		}
+	}
+}
+
+// scheduledRun runs the workflow once, and logs the summary of the run.
+func (c *Cmd) scheduledRun() {
+	start := time.Now()
+	p, err := c.run()
+	if err != nil {
+		fmt.Printf("%s\n", err)
+	}
+	status := exitStatus(p, err)
+	fmt.Printf("schedule: run at %s finished in %s with exit status %d\n",
+		start.Format(time.RFC3339), time.Since(start).Round(time.Millisecond), status)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const watchDebounce = 200 * time.Millisecond
+
+// watch runs the workflow, and runs it again whenever the workflow file or the vars files change.
+// Directories are watched instead of files, because editors often replace a file on save.
+func (c *Cmd) watch() int {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		fmt.Printf("%#v\n", err)
+		return 1
+	}
+	defer w.Close()
+
+	files := map[string]bool{}
+	dirs := map[string]bool{}
+	run := func() {
+		fmt.Print("\033[H\033[2J")
+		p, err := c.run()
+		if err != nil {
+			fmt.Printf("%#v\n", err)
+		}
+		if p == nil {
+			return
+		}
+
+		files = map[string]bool{}
+		for _, f := range p.Files() {
+			abs, err := filepath.Abs(f)
+			if err != nil {
+				continue
+			}
+			files[abs] = true
+			dir := filepath.Dir(abs)
+			if dirs[dir] {
+				continue
+			}
+			if err := w.Add(dir); err != nil {
+				fmt.Printf("watch error: %s\n", err)
+				continue
+			}
+			dirs[dir] = true
+		}
+		fmt.Printf("\nWatching for changes... (ctrl-c to quit)\n")
+	}
+
+	run()
+
+	// Rapid successive writes are debounced into one run
+	timer := time.NewTimer(watchDebounce)
+	timer.Stop()
+
+	for {
+		select {
+		case ev, ok := <-w.Events:
+			if !ok {
+				return 0
+			}
+			if !ev.Has(fsnotify.Write) && !ev.Has(fsnotify.Create) {
+				continue
+			}
+			if abs, err := filepath.Abs(ev.Name); err == nil && files[abs] {
+				timer.Reset(watchDebounce)
+			}
+		case err, ok := <-w.Errors:
+			if !ok {
+				return 0
+			}
+			fmt.Printf("watch error: %s\n", err)
+		case <-timer.C:
+			run()
+		}
+	}
+}
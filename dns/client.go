@@ -0,0 +1,205 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/linyows/probe"
+)
+
+const (
+	StatusNoError  = "NOERROR"
+	StatusNXDomain = "NXDOMAIN"
+)
+
+type Req struct {
+	Name    string        `map:"name" validate:"required"`
+	Type    string        `map:"type"`
+	Server  string        `map:"server"`
+	Timeout time.Duration `map:"timeout"`
+	cb      *Callback
+}
+
+type Res struct {
+	Status  string        `map:"status"`
+	Records []string      `map:"records"`
+	RT      time.Duration `map:"rt"`
+}
+
+type Result struct {
+	Req Req `map:"req"`
+	Res Res `map:"res"`
+}
+
+func NewReq() *Req {
+	return &Req{
+		Type:    "A",
+		Timeout: 5 * time.Second,
+	}
+}
+
+// Do resolves the name for the record type. A non-existent domain is not an error,
+// and is reported with the NXDOMAIN status.
+func (r *Req) Do() (*Result, error) {
+	if r.Name == "" {
+		return nil, errors.New("Req.Name is required")
+	}
+	r.Type = strings.ToUpper(r.Type)
+
+	// callback
+	if r.cb != nil && r.cb.before != nil {
+		r.cb.before(r.Name, r.Type, r.Server)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.Timeout)
+	defer cancel()
+
+	result := &Result{Req: *r}
+	start := time.Now()
+	records, err := r.lookup(ctx, r.resolver())
+	result.Res.RT = time.Since(start)
+
+	var dnsErr *net.DNSError
+	switch {
+	case err == nil:
+		result.Res.Status = StatusNoError
+		result.Res.Records = records
+	case errors.As(err, &dnsErr) && dnsErr.IsNotFound:
+		result.Res.Status = StatusNXDomain
+		result.Res.Records = []string{}
+	default:
+		return nil, err
+	}
+
+	// callback
+	if r.cb != nil && r.cb.after != nil {
+		r.cb.after(&result.Res)
+	}
+
+	return result, nil
+}
+
+func (r *Req) resolver() *net.Resolver {
+	if r.Server == "" {
+		return net.DefaultResolver
+	}
+
+	server := r.Server
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			d := net.Dialer{Timeout: r.Timeout}
+			return d.DialContext(ctx, network, server)
+		},
+	}
+}
+
+func (r *Req) lookup(ctx context.Context, res *net.Resolver) ([]string, error) {
+	records := []string{}
+
+	switch r.Type {
+	case "A", "AAAA":
+		network := "ip4"
+		if r.Type == "AAAA" {
+			network = "ip6"
+		}
+		ips, err := res.LookupIP(ctx, network, r.Name)
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range ips {
+			records = append(records, ip.String())
+		}
+
+	case "CNAME":
+		cname, err := res.LookupCNAME(ctx, r.Name)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, cname)
+
+	case "MX":
+		mxs, err := res.LookupMX(ctx, r.Name)
+		if err != nil {
+			return nil, err
+		}
+		for _, mx := range mxs {
+			records = append(records, fmt.Sprintf("%d %s", mx.Pref, mx.Host))
+		}
+
+	case "TXT":
+		txts, err := res.LookupTXT(ctx, r.Name)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, txts...)
+
+	case "NS":
+		nss, err := res.LookupNS(ctx, r.Name)
+		if err != nil {
+			return nil, err
+		}
+		for _, ns := range nss {
+			records = append(records, ns.Host)
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported record type: %s", r.Type)
+	}
+
+	return records, nil
+}
+
+type Option func(*Callback)
+
+type Callback struct {
+	before func(name, typ, server string)
+	after  func(res *Res)
+}
+
+func Request(data map[string]string, opts ...Option) (map[string]string, error) {
+	m := probe.UnflattenInterface(data)
+	r := NewReq()
+
+	cb := &Callback{}
+	for _, opt := range opts {
+		opt(cb)
+	}
+	r.cb = cb
+
+	if err := probe.MapToStructByTags(m, r); err != nil {
+		return map[string]string{}, err
+	}
+
+	ret, err := r.Do()
+	if err != nil {
+		return map[string]string{}, err
+	}
+
+	mapRet, err := probe.StructToMapByTags(ret)
+	if err != nil {
+		return map[string]string{}, err
+	}
+
+	return probe.FlattenInterface(mapRet), nil
+}
+
+func WithBefore(f func(name, typ, server string)) Option {
+	return func(c *Callback) {
+		c.before = f
+	}
+}
+
+func WithAfter(f func(res *Res)) Option {
+	return func(c *Callback) {
+		c.after = f
+	}
+}
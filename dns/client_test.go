@@ -0,0 +1,118 @@
+package dns
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// newServer starts a udp dns server that answers only for example.com.
+func newServer(t *testing.T) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var msg dnsmessage.Message
+			if err := msg.Unpack(buf[:n]); err != nil || len(msg.Questions) == 0 {
+				continue
+			}
+			q := msg.Questions[0]
+			msg.Header.Response = true
+			msg.Header.Authoritative = true
+
+			hdr := dnsmessage.ResourceHeader{Name: q.Name, Type: q.Type, Class: dnsmessage.ClassINET, TTL: 60}
+			switch {
+			case q.Name.String() != "example.com.":
+				msg.Header.RCode = dnsmessage.RCodeNameError
+			case q.Type == dnsmessage.TypeA:
+				msg.Answers = append(msg.Answers, dnsmessage.Resource{Header: hdr, Body: &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}}})
+			case q.Type == dnsmessage.TypeMX:
+				mx := dnsmessage.MustNewName("mx.example.com.")
+				msg.Answers = append(msg.Answers, dnsmessage.Resource{Header: hdr, Body: &dnsmessage.MXResource{Pref: 10, MX: mx}})
+			case q.Type == dnsmessage.TypeTXT:
+				msg.Answers = append(msg.Answers, dnsmessage.Resource{Header: hdr, Body: &dnsmessage.TXTResource{TXT: []string{"v=spf1 -all"}}})
+			}
+
+			out, err := msg.Pack()
+			if err != nil {
+				continue
+			}
+			pc.WriteTo(out, addr)
+		}
+	}()
+
+	return pc.LocalAddr().String()
+}
+
+func TestDo(t *testing.T) {
+	server := newServer(t)
+
+	tests := []struct {
+		name    string
+		typ     string
+		status  string
+		records []string
+	}{
+		{"example.com.", "a", StatusNoError, []string{"192.0.2.1"}},
+		{"example.com.", "MX", StatusNoError, []string{"10 mx.example.com."}},
+		{"example.com.", "TXT", StatusNoError, []string{"v=spf1 -all"}},
+		{"missing.example.com.", "A", StatusNXDomain, []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name+" "+tt.typ, func(t *testing.T) {
+			req := NewReq()
+			req.Name = tt.name
+			req.Type = tt.typ
+			req.Server = server
+
+			got, err := req.Do()
+			if err != nil {
+				t.Fatalf("got error %s", err)
+			}
+			if got.Res.Status != tt.status {
+				t.Errorf("expected status %s, got %s", tt.status, got.Res.Status)
+			}
+			if !reflect.DeepEqual(got.Res.Records, tt.records) {
+				t.Errorf("expected records %#v, got %#v", tt.records, got.Res.Records)
+			}
+		})
+	}
+}
+
+func TestDoUnsupportedType(t *testing.T) {
+	req := NewReq()
+	req.Name = "example.com."
+	req.Type = "SRV"
+	req.Server = newServer(t)
+
+	if _, err := req.Do(); err == nil || err.Error() != "unsupported record type: SRV" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestRequest(t *testing.T) {
+	got, err := Request(map[string]string{
+		"name":    "example.com.",
+		"server":  newServer(t),
+		"timeout": "2s",
+	})
+	if err != nil {
+		t.Fatalf("got error %s", err)
+	}
+	if got["res__status"] != StatusNoError || got["res__records__0"] != "192.0.2.1" || got["req__type"] != "A" {
+		t.Errorf("unexpected result %#v", got)
+	}
+}
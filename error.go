@@ -3,8 +3,45 @@ package probe
 import (
 	"fmt"
 	"strings"
+	"time"
 )
 
+// Exit codes of the probe command by the failure category
+const (
+	ExitSuccess     = 0
+	ExitTestFailure = 1
+	ExitActionError = 2
+	ExitConfigError = 3
+	ExitTimeout     = 4
+)
+
+// ConfigError is an error in the workflow or the options, found before any job runs.
+type ConfigError struct {
+	Err error
+}
+
+func (e *ConfigError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+// ActionError is an error of an action failed to run, such as a plugin failed to start.
+type ActionError struct {
+	Action string
+	Err    error
+}
+
+func (e *ActionError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ActionError) Unwrap() error {
+	return e.Err
+}
+
 type ValidationError struct {
 	messages []string
 }
@@ -20,3 +57,22 @@ func (e *ValidationError) HasError() bool {
 func (e *ValidationError) AddMessage(s string) {
 	e.messages = append(e.messages, s)
 }
+
+type TimeoutError struct {
+	Action  string
+	Timeout time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("action '%s' timed out after %s", e.Action, e.Timeout)
+}
+
+type WaitTimeoutError struct {
+	Condition string
+	Timeout   time.Duration
+	Polls     int
+}
+
+func (e *WaitTimeoutError) Error() string {
+	return fmt.Sprintf("condition '%s' was not met within %s (%d polls)", e.Condition, e.Timeout, e.Polls)
+}
@@ -0,0 +1,86 @@
+package probe
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+
+	EventWorkflowStart = "workflow_start"
+	EventWorkflowEnd   = "workflow_end"
+	EventJobStart      = "job_start"
+	EventJobEnd        = "job_end"
+	EventStepStart     = "step_start"
+	EventStepEnd       = "step_end"
+	EventTest          = "test"
+)
+
+func IsLogFormat(f string) bool {
+	return f == "" || f == LogFormatText || f == LogFormatJSON
+}
+
+// Event is a line of the json log. Ids of jobs and steps are their indexes in the workflow and the job.
+type Event struct {
+	Time       time.Time `json:"time"`
+	Event      string    `json:"event"`
+	Workflow   string    `json:"workflow"`
+	JobID      *int      `json:"job_id,omitempty"`
+	Job        string    `json:"job,omitempty"`
+	StepID     *int      `json:"step_id,omitempty"`
+	Step       string    `json:"step,omitempty"`
+	Uses       string    `json:"uses,omitempty"`
+	Status     string    `json:"status,omitempty"`
+	Test       string    `json:"test,omitempty"`
+	Passed     *bool     `json:"passed,omitempty"`
+	Message    string    `json:"message,omitempty"`
+	DurationMs float64   `json:"duration_ms,omitempty"`
+}
+
+// eventListener receives events of the run, like the json log and the progress.
+type eventListener interface {
+	emit(Event)
+}
+
+type eventListeners []eventListener
+
+func (ls eventListeners) emit(e Event) {
+	for _, l := range ls {
+		l.emit(e)
+	}
+}
+
+// eventLog writes events as json lines. Methods of the nil log do nothing, so that callers need not check the format.
+type eventLog struct {
+	w        io.Writer
+	workflow string
+	mu       sync.Mutex
+}
+
+func newEventLog(w io.Writer, workflow string) *eventLog {
+	return &eventLog{w: w, workflow: workflow}
+}
+
+func (l *eventLog) emit(e Event) {
+	if l == nil {
+		return
+	}
+	e.Time = time.Now()
+	e.Workflow = l.workflow
+	b, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, _ = l.w.Write(append(b, '\n'))
+}
+
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
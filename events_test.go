@@ -0,0 +1,68 @@
+package probe
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func TestJSONLogFormat(t *testing.T) {
+	stubRunActions(t, func(ctx context.Context, name string, args []string, with map[string]any, verbose bool) (map[string]any, error) {
+		return map[string]any{"req": map[string]any{}, "res": map[string]any{"code": 500}}, nil
+	})
+
+	wf := &Workflow{
+		Name: "Health",
+		Jobs: []Job{
+			{Name: "API", Steps: []*Step{{Name: "Get users", Uses: "http", Test: "res.code == 200"}}},
+		},
+		env: map[string]string{},
+	}
+	var buf bytes.Buffer
+	if err := wf.Start(Config{Log: &buf, LogFormat: LogFormatJSON}); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	var events []Event
+	for _, line := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+		var e Event
+		if err := json.Unmarshal(line, &e); err != nil {
+			t.Fatalf("expected json lines only, got %q: %s", line, err)
+		}
+		events = append(events, e)
+	}
+
+	expected := []struct {
+		event  string
+		status string
+	}{
+		{EventWorkflowStart, ""},
+		{EventJobStart, ""},
+		{EventStepStart, ""},
+		{EventTest, ""},
+		{EventStepEnd, StatusFailure},
+		{EventJobEnd, StatusFailure},
+		{EventWorkflowEnd, StatusFailure},
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("expected %d events, got %s", len(expected), buf.String())
+	}
+	for i, e := range expected {
+		got := events[i]
+		if got.Event != e.event || got.Status != e.status || got.Workflow != "Health" || got.Time.IsZero() {
+			t.Errorf("events[%d]: expected %s %q, got %+v", i, e.event, e.status, got)
+		}
+	}
+
+	test := events[3]
+	if test.JobID == nil || *test.JobID != 0 || test.Job != "API" || test.StepID == nil || test.Step != "Get users" {
+		t.Errorf("expected ids and names of the job and the step, got %+v", test)
+	}
+	if test.Passed == nil || *test.Passed || test.Test != "res.code == 200" {
+		t.Errorf("expected the failed test, got %+v", test)
+	}
+	if events[4].DurationMs <= 0 {
+		t.Errorf("expected the duration of the step, got %+v", events[4])
+	}
+}
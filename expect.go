@@ -0,0 +1,85 @@
+package probe
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// expectResult is a check of the expect shortcuts of the step.
+type expectResult struct {
+	name    string
+	passed  bool
+	message string
+}
+
+// checkExpect checks the response by expect_status, expect_body_contains and expect_header of the step.
+// Expected values can be templates. Headers match when the value contains the expected value,
+// so that `content-type: application/json` matches with the charset.
+func (st *Step) checkExpect() []expectResult {
+	var results []expectResult
+	eval := func(s string) string {
+		out, err := st.expr.EvalTemplate(s, st.ctx)
+		if err != nil {
+			return s
+		}
+		return out
+	}
+	res := st.ctx.Res
+
+	if st.ExpectStatus != 0 {
+		got, _ := AnyToString(res["code"])
+		r := expectResult{name: fmt.Sprintf("expect_status: %d", st.ExpectStatus)}
+		if r.passed = got == fmt.Sprint(st.ExpectStatus); !r.passed {
+			r.message = fmt.Sprintf("expected status %d, got %s", st.ExpectStatus, got)
+		}
+		results = append(results, r)
+	}
+
+	if st.ExpectBodyContains != "" {
+		want := eval(st.ExpectBodyContains)
+		body, ok := res["rawbody"].(string)
+		if !ok {
+			body, _ = AnyToString(res["body"])
+		}
+		r := expectResult{name: fmt.Sprintf("expect_body_contains: %s", want)}
+		if r.passed = strings.Contains(body, want); !r.passed {
+			r.message = fmt.Sprintf("expected body to contain %q", want)
+		}
+		results = append(results, r)
+	}
+
+	names := make([]string, 0, len(st.ExpectHeader))
+	for k := range st.ExpectHeader {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	headers, _ := res["headers"].(map[string]any)
+	for _, k := range names {
+		want := eval(st.ExpectHeader[k])
+		var got string
+		found := false
+		for hk, hv := range headers {
+			if strings.EqualFold(hk, k) {
+				got, _ = AnyToString(hv)
+				found = true
+				break
+			}
+		}
+		r := expectResult{name: fmt.Sprintf("expect_header: %s: %s", k, want)}
+		switch r.passed = found && strings.Contains(got, want); {
+		case !found:
+			r.message = fmt.Sprintf("expected header %s, but it is missing", k)
+		case !r.passed:
+			r.message = fmt.Sprintf("expected header %s to contain %q, got %q", k, want, got)
+		}
+		results = append(results, r)
+	}
+
+	return results
+}
+
+// hasExpect reports whether the step has any expect shortcuts.
+func (st *Step) hasExpect() bool {
+	return st.ExpectStatus != 0 || st.ExpectBodyContains != "" || len(st.ExpectHeader) > 0
+}
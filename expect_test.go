@@ -0,0 +1,97 @@
+package probe
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCheckExpect(t *testing.T) {
+	res := map[string]any{
+		"code":    "200",
+		"body":    map[string]any{"status": "ok"},
+		"rawbody": `{"status": "ok"}`,
+		"headers": map[string]any{"content-type": "application/json; charset=utf-8"},
+	}
+
+	tests := []struct {
+		name     string
+		step     *Step
+		expected []expectResult
+	}{
+		{
+			name:     "status",
+			step:     &Step{ExpectStatus: 200},
+			expected: []expectResult{{name: "expect_status: 200", passed: true}},
+		},
+		{
+			name:     "status mismatch",
+			step:     &Step{ExpectStatus: 201},
+			expected: []expectResult{{name: "expect_status: 201", message: "expected status 201, got 200"}},
+		},
+		{
+			name:     "body contains",
+			step:     &Step{ExpectBodyContains: `"status": "{vars.status}"`},
+			expected: []expectResult{{name: `expect_body_contains: "status": "ok"`, passed: true}},
+		},
+		{
+			name:     "body does not contain",
+			step:     &Step{ExpectBodyContains: "error"},
+			expected: []expectResult{{name: "expect_body_contains: error", message: `expected body to contain "error"`}},
+		},
+		{
+			name: "headers",
+			step: &Step{ExpectHeader: map[string]string{"Content-Type": "application/json", "x-request-id": "1"}},
+			expected: []expectResult{
+				{name: "expect_header: Content-Type: application/json", passed: true},
+				{name: "expect_header: x-request-id: 1", message: "expected header x-request-id, but it is missing"},
+			},
+		},
+		{
+			name:     "header mismatch",
+			step:     &Step{ExpectHeader: map[string]string{"content-type": "text/html"}},
+			expected: []expectResult{{name: "expect_header: content-type: text/html", message: `expected header content-type to contain "text/html", got "application/json; charset=utf-8"`}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := tt.step
+			st.expr = &Expr{}
+			st.ctx = StepContext{Vars: map[string]any{"status": "ok"}, Res: res}
+			if actual := st.checkExpect(); !reflect.DeepEqual(actual, tt.expected) {
+				t.Errorf("expected %+v, got %+v", tt.expected, actual)
+			}
+		})
+	}
+}
+
+func TestStepExpect(t *testing.T) {
+	stubRunActions(t, func(ctx context.Context, name string, args []string, with map[string]any, verbose bool) (map[string]any, error) {
+		return map[string]any{"req": map[string]any{}, "res": map[string]any{"code": 500, "body": "internal error"}}, nil
+	})
+
+	steps := []*Step{
+		{Uses: "http", ExpectStatus: 500, ExpectBodyContains: "error"},
+		{Uses: "http", ExpectStatus: 200, Test: "res.code == 500"},
+	}
+	var buf bytes.Buffer
+	wf := &Workflow{Name: "Test", Jobs: []Job{{Name: "Job", Steps: steps}}, env: map[string]string{}}
+	if err := wf.Start(Config{Log: &buf, LogFormat: LogFormatJSON}); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	results := wf.Result().Jobs[0].Steps
+	if results[0].Status != StatusSuccess {
+		t.Errorf("expected the passed expects to succeed, got %+v", results[0])
+	}
+	if results[1].Status != StatusFailure || !strings.Contains(results[1].Message, "expected status 200, got 500") {
+		t.Errorf("expected the failed expect to fail the step, got %+v", results[1])
+	}
+	// the expects and the test are reported individually
+	if n := strings.Count(buf.String(), `"event":"test"`); n != 4 {
+		t.Errorf("expected 4 test events, got %d in %s", n, buf.String())
+	}
+}
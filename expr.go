@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"regexp"
 	"strings"
+	"sync"
 
 	ex "github.com/expr-lang/expr"
 )
@@ -17,27 +18,46 @@ var (
 
 type Expr struct{}
 
+// ExprFunc is a function callable in expressions, like `test`, `echo` and templates.
+type ExprFunc func(params ...any) (any, error)
+
+var (
+	exprFuncs = map[string]ExprFunc{
+		"match_json":    exprMatchJSON,
+		"diff_json":     exprDiffJSON,
+		"now":           exprNow,
+		"getenv":        exprGetenv,
+		"uuid":          exprUUID,
+		"base64":        exprBase64,
+		"base64_decode": exprBase64Decode,
+		"jsonpath":      exprJSONPath,
+		"match":         exprMatch,
+		"regex_find":    exprRegexFind,
+	}
+	exprFuncsMu sync.RWMutex
+)
+
+// RegisterExprFunc makes fn callable by the name in every expression.
+// It replaces the function of the same name, including built-in ones.
+func RegisterExprFunc(name string, fn ExprFunc) {
+	exprFuncsMu.Lock()
+	defer exprFuncsMu.Unlock()
+	exprFuncs[name] = fn
+}
+
 func (e *Expr) Options(env any) []ex.Option {
-	return []ex.Option{
+	opts := []ex.Option{
 		ex.Env(env),
 		ex.AllowUndefinedVariables(),
-		ex.Function(
-			"match_json",
-			func(params ...any) (any, error) {
-				src := params[0].(map[string]any)
-				target := params[1].(map[string]any)
-				return MatchJSON(src, target), nil
-			},
-		),
-		ex.Function(
-			"diff_json",
-			func(params ...any) (any, error) {
-				src := params[0].(map[string]any)
-				target := params[1].(map[string]any)
-				return DiffJSON(src, target), nil
-			},
-		),
 	}
+
+	exprFuncsMu.RLock()
+	defer exprFuncsMu.RUnlock()
+	for name, fn := range exprFuncs {
+		opts = append(opts, ex.Function(name, fn))
+	}
+
+	return opts
 }
 
 func (e *Expr) EvalOrEvalTemplate(input string, env any) (string, error) {
@@ -2,7 +2,9 @@ package probe
 
 import (
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestEvalTemplateMap(t *testing.T) {
@@ -86,3 +88,86 @@ func TestEvalTemplate(t *testing.T) {
 		})
 	}
 }
+
+func TestExprFuncs(t *testing.T) {
+	t.Setenv("PROBE_TEST_TOKEN", "secret")
+	env := map[string]any{
+		"res": map[string]any{
+			"body":    map[string]any{"items": []any{map[string]any{"name": "probe"}}},
+			"raw":     `{"id": 1}`,
+			"version": "v1.2.3",
+		},
+	}
+
+	tests := []struct {
+		name     string
+		input    string
+		expected any
+	}{
+		{name: "match_json", input: `match_json({"id": 1}, {"id": "/^\\d$/"})`, expected: true},
+		{name: "diff_json", input: `diff_json({"id": 1}, {"id": 1})`, expected: "No diff"},
+		{name: "now with layout", input: `now("2006")`, expected: time.Now().Format("2006")},
+		{name: "now in location", input: `now(timezone("Asia/Tokyo")).Location().String()`, expected: "Asia/Tokyo"},
+		{name: "builtin find", input: `find([{"id": 1}, {"id": 2}], .id == 2)`, expected: map[string]any{"id": 2}},
+		{name: "getenv", input: `getenv("PROBE_TEST_TOKEN")`, expected: "secret"},
+		{name: "getenv with default", input: `getenv("PROBE_TEST_UNDEFINED", "default")`, expected: "default"},
+		{name: "uuid", input: `uuid() matches "^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[0-9a-f]{4}-[0-9a-f]{12}$"`, expected: true},
+		{name: "base64", input: `base64("user:pass")`, expected: "dXNlcjpwYXNz"},
+		{name: "base64_decode", input: `base64_decode("dXNlcjpwYXNz")`, expected: "user:pass"},
+		{name: "jsonpath", input: `jsonpath(res.body, "$.items[0].name")`, expected: "probe"},
+		{name: "jsonpath of json string", input: `jsonpath(res.raw, "id")`, expected: float64(1)},
+		{name: "match", input: `match("^v\\d+\\.\\d+", res.version)`, expected: true},
+		{name: "match with no match", input: `match("^v\\d+$", res.version)`, expected: false},
+		{name: "match nil", input: `match(".*", res.missing)`, expected: false},
+		{name: "regex_find capture", input: `regex_find("id=(\\d+)", "user id=42 ok")`, expected: "42"},
+		{name: "regex_find without groups", input: `regex_find("\\d+", "user id=42 ok")`, expected: "42"},
+		{name: "regex_find with no match", input: `regex_find("id=(\\d+)", res.version) ?? "none"`, expected: "none"},
+		{name: "jsonpath missing", input: `jsonpath(res.body, "$.items[1].name") ?? "none"`, expected: "none"},
+	}
+
+	expr := &Expr{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual, err := expr.Eval(tt.input, env)
+			if err != nil {
+				t.Fatalf("unexpected error %s", err)
+			}
+			if !reflect.DeepEqual(tt.expected, actual) {
+				t.Errorf("expected %#v, got %#v", tt.expected, actual)
+			}
+		})
+	}
+
+	if now, err := expr.Eval(`now()`, env); err != nil {
+		t.Errorf("unexpected error %s", err)
+	} else if _, ok := now.(time.Time); !ok {
+		t.Errorf("expected now() to be time.Time, got %T", now)
+	}
+
+	if _, err := expr.Eval(`base64_decode("!")`, env); err == nil {
+		t.Error("expected an error of invalid base64")
+	}
+	if _, err := expr.Eval(`match("(", "a")`, env); err == nil || !strings.Contains(err.Error(), `match: invalid pattern "("`) {
+		t.Errorf("expected an error of the invalid pattern, got %v", err)
+	}
+}
+
+func TestRegisterExprFunc(t *testing.T) {
+	RegisterExprFunc("twice", func(params ...any) (any, error) {
+		return strings.Repeat(params[0].(string), 2), nil
+	})
+	t.Cleanup(func() {
+		exprFuncsMu.Lock()
+		delete(exprFuncs, "twice")
+		exprFuncsMu.Unlock()
+	})
+
+	expr := &Expr{}
+	actual, err := expr.EvalTemplate("{twice('ab')}", map[string]any{})
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if actual != "abab" {
+		t.Errorf("expected abab, got %s", actual)
+	}
+}
@@ -0,0 +1,200 @@
+package file
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/linyows/probe"
+)
+
+const (
+	OpExists = "exists"
+	OpStat   = "stat"
+	OpRead   = "read"
+	OpHash   = "hash"
+)
+
+type Req struct {
+	Path      string `map:"path" validate:"required"`
+	Operation string `map:"operation"`
+	MaxBytes  int    `map:"max_bytes"`
+	Algorithm string `map:"algorithm"`
+	cb        *Callback
+}
+
+type Res struct {
+	Exists    bool   `map:"exists"`
+	IsDir     bool   `map:"is_dir"`
+	Size      int64  `map:"size"`
+	Mode      string `map:"mode"`
+	ModTime   string `map:"mod_time"`
+	Content   string `map:"content,omitempty"`
+	Truncated bool   `map:"truncated"`
+	Checksum  string `map:"checksum,omitempty"`
+}
+
+type Result struct {
+	Req Req `map:"req"`
+	Res Res `map:"res"`
+}
+
+func NewReq() *Req {
+	return &Req{
+		Operation: OpStat,
+		MaxBytes:  1024 * 1024,
+		Algorithm: "sha256",
+	}
+}
+
+// Do runs the operation on the path. A missing file is an error except for the exists operation.
+func (r *Req) Do() (*Result, error) {
+	if r.Path == "" {
+		return nil, errors.New("Req.Path is required")
+	}
+	switch r.Operation {
+	case OpExists, OpStat, OpRead, OpHash:
+	default:
+		return nil, fmt.Errorf("unsupported operation: %s", r.Operation)
+	}
+
+	// callback
+	if r.cb != nil && r.cb.before != nil {
+		r.cb.before(r.Operation, r.Path)
+	}
+
+	result := &Result{Req: *r}
+
+	info, err := os.Stat(r.Path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) && r.Operation == OpExists {
+			return result, nil
+		}
+		return nil, err
+	}
+	result.Res.Exists = true
+	result.Res.IsDir = info.IsDir()
+	result.Res.Size = info.Size()
+	result.Res.Mode = info.Mode().String()
+	result.Res.ModTime = info.ModTime().Format(time.RFC3339)
+
+	switch r.Operation {
+	case OpRead:
+		if err := r.read(&result.Res); err != nil {
+			return nil, err
+		}
+	case OpHash:
+		if err := r.hash(&result.Res); err != nil {
+			return nil, err
+		}
+	}
+
+	// callback
+	if r.cb != nil && r.cb.after != nil {
+		r.cb.after(&result.Res)
+	}
+
+	return result, nil
+}
+
+func (r *Req) read(res *Res) error {
+	f, err := os.Open(r.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// read one more byte to know whether the content is truncated
+	b, err := io.ReadAll(io.LimitReader(f, int64(r.MaxBytes)+1))
+	if err != nil {
+		return err
+	}
+	if len(b) > r.MaxBytes {
+		b = b[:r.MaxBytes]
+		res.Truncated = true
+	}
+	res.Content = string(b)
+
+	return nil
+}
+
+func (r *Req) hash(res *Res) error {
+	var h hash.Hash
+	switch r.Algorithm {
+	case "sha256":
+		h = sha256.New()
+	case "sha1":
+		h = sha1.New()
+	case "md5":
+		h = md5.New()
+	default:
+		return fmt.Errorf("unsupported algorithm: %s", r.Algorithm)
+	}
+
+	f, err := os.Open(r.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	res.Checksum = hex.EncodeToString(h.Sum(nil))
+
+	return nil
+}
+
+type Option func(*Callback)
+
+type Callback struct {
+	before func(op, path string)
+	after  func(res *Res)
+}
+
+func Request(data map[string]string, opts ...Option) (map[string]string, error) {
+	m := probe.UnflattenInterface(data, "path")
+	r := NewReq()
+
+	cb := &Callback{}
+	for _, opt := range opts {
+		opt(cb)
+	}
+	r.cb = cb
+
+	if err := probe.MapToStructByTags(m, r); err != nil {
+		return map[string]string{}, err
+	}
+
+	ret, err := r.Do()
+	if err != nil {
+		return map[string]string{}, err
+	}
+
+	mapRet, err := probe.StructToMapByTags(ret)
+	if err != nil {
+		return map[string]string{}, err
+	}
+
+	return probe.FlattenInterface(mapRet), nil
+}
+
+func WithBefore(f func(op, path string)) Option {
+	return func(c *Callback) {
+		c.before = f
+	}
+}
+
+func WithAfter(f func(res *Res)) Option {
+	return func(c *Callback) {
+		c.after = f
+	}
+}
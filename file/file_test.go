@@ -0,0 +1,111 @@
+package file
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "result.csv")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestDo(t *testing.T) {
+	path := writeFile(t, "id,latency\n1,120\n")
+
+	tests := []struct {
+		name   string
+		modify func(*Req)
+		expect func(*testing.T, Res)
+	}{
+		{
+			name:   "stat",
+			modify: func(r *Req) {},
+			expect: func(t *testing.T, res Res) {
+				if !res.Exists || res.IsDir || res.Size != 17 || res.Mode != "-rw-r--r--" || res.Content != "" {
+					t.Errorf("unexpected result %#v", res)
+				}
+			},
+		},
+		{
+			name:   "read",
+			modify: func(r *Req) { r.Operation = OpRead },
+			expect: func(t *testing.T, res Res) {
+				if res.Content != "id,latency\n1,120\n" || res.Truncated {
+					t.Errorf("unexpected result %#v", res)
+				}
+			},
+		},
+		{
+			name:   "read with max bytes",
+			modify: func(r *Req) { r.Operation = OpRead; r.MaxBytes = 10 },
+			expect: func(t *testing.T, res Res) {
+				if res.Content != "id,latency" || !res.Truncated {
+					t.Errorf("unexpected result %#v", res)
+				}
+			},
+		},
+		{
+			name:   "hash",
+			modify: func(r *Req) { r.Operation = OpHash; r.Algorithm = "md5" },
+			expect: func(t *testing.T, res Res) {
+				if res.Checksum != "1f0cb8053ad143479cca9ea572d44628" {
+					t.Errorf("unexpected checksum %s", res.Checksum)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := NewReq()
+			req.Path = path
+			tt.modify(req)
+
+			got, err := req.Do()
+			if err != nil {
+				t.Fatalf("got error %s", err)
+			}
+			tt.expect(t, got.Res)
+		})
+	}
+}
+
+func TestDoNotExist(t *testing.T) {
+	req := NewReq()
+	req.Path = filepath.Join(t.TempDir(), "missing")
+
+	if _, err := req.Do(); err == nil {
+		t.Error("expected error for stat on a missing file")
+	}
+
+	req.Operation = OpExists
+	got, err := req.Do()
+	if err != nil {
+		t.Fatalf("got error %s", err)
+	}
+	if got.Res.Exists {
+		t.Errorf("expected not to exist, got %#v", got.Res)
+	}
+}
+
+func TestRequest(t *testing.T) {
+	path := writeFile(t, "hello")
+
+	got, err := Request(map[string]string{
+		"path":      path,
+		"operation": "hash",
+	})
+	if err != nil {
+		t.Fatalf("got error %s", err)
+	}
+	expected := "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+	if got["res__exists"] != "true" || got["res__size"] != "5" || got["res__checksum"] != expected {
+		t.Errorf("unexpected result %#v", got)
+	}
+}
@@ -1,9 +1,17 @@
 package probe
 
 import (
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"os"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
 )
 
 // MatchJSON compares two `map[string]any` objects strictly.
@@ -226,3 +234,259 @@ func deepMatchWithDiffs(src, target interface{}, diffs *[]string, path string) b
 		return true
 	}
 }
+
+// Functions built in expressions. See RegisterExprFunc to add functions.
+
+// match_json(src, target) reports whether src matches target, where strings like /regexp/ in target match as regexps.
+func exprMatchJSON(params ...any) (any, error) {
+	src, target, err := jsonPair("match_json", params)
+	if err != nil {
+		return nil, err
+	}
+	return MatchJSON(src, target), nil
+}
+
+// diff_json(src, target) returns differences between src and target.
+func exprDiffJSON(params ...any) (any, error) {
+	src, target, err := jsonPair("diff_json", params)
+	if err != nil {
+		return nil, err
+	}
+	return DiffJSON(src, target), nil
+}
+
+// now() returns the current time, and now(layout) formats it by the go layout like "2006-01-02".
+// now(timezone(name)) returns the current time in the location, as the builtin it replaces.
+func exprNow(params ...any) (any, error) {
+	switch len(params) {
+	case 0:
+		return time.Now(), nil
+	case 1:
+		switch v := params[0].(type) {
+		case string:
+			return time.Now().Format(v), nil
+		case *time.Location:
+			return time.Now().In(v), nil
+		}
+		return nil, fmt.Errorf("now: layout must be a string or a location, got %T", params[0])
+	}
+	return nil, fmt.Errorf("now: expected at most 1 argument, got %d", len(params))
+}
+
+// getenv(name) returns the environment variable, and getenv(name, default) returns default when it is empty.
+// It is not named env, because env is the map of environment variables in expressions.
+func exprGetenv(params ...any) (any, error) {
+	if len(params) < 1 || len(params) > 2 {
+		return nil, fmt.Errorf("getenv: expected 1 or 2 arguments, got %d", len(params))
+	}
+	name, ok := params[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("getenv: name must be a string, got %T", params[0])
+	}
+	if v := os.Getenv(name); v != "" || len(params) == 1 {
+		return v, nil
+	}
+	return params[1], nil
+}
+
+// uuid() returns a random uuid v4.
+func exprUUID(params ...any) (any, error) {
+	if len(params) != 0 {
+		return nil, fmt.Errorf("uuid: expected no arguments, got %d", len(params))
+	}
+	return uuid.NewString(), nil
+}
+
+// base64(s) encodes s by the standard base64 encoding.
+func exprBase64(params ...any) (any, error) {
+	if len(params) != 1 {
+		return nil, fmt.Errorf("base64: expected 1 argument, got %d", len(params))
+	}
+	switch v := params[0].(type) {
+	case string:
+		return base64.StdEncoding.EncodeToString([]byte(v)), nil
+	case []byte:
+		return base64.StdEncoding.EncodeToString(v), nil
+	}
+	return nil, fmt.Errorf("base64: expected a string, got %T", params[0])
+}
+
+// base64_decode(s) decodes s encoded by the standard base64 encoding.
+func exprBase64Decode(params ...any) (any, error) {
+	if len(params) != 1 {
+		return nil, fmt.Errorf("base64_decode: expected 1 argument, got %d", len(params))
+	}
+	s, ok := params[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("base64_decode: expected a string, got %T", params[0])
+	}
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("base64_decode: %w", err)
+	}
+	return string(b), nil
+}
+
+// jsonpath(v, path) returns the value at the path like "$.items[0].name" in v, or nil when it is missing.
+func exprJSONPath(params ...any) (any, error) {
+	if len(params) != 2 {
+		return nil, fmt.Errorf("jsonpath: expected 2 arguments, got %d", len(params))
+	}
+	path, ok := params[1].(string)
+	if !ok {
+		return nil, fmt.Errorf("jsonpath: path must be a string, got %T", params[1])
+	}
+	v, err := JSONPath(params[0], path)
+	if err != nil {
+		return nil, fmt.Errorf("jsonpath: %w", err)
+	}
+	return v, nil
+}
+
+// match(pattern, s) reports whether s contains a match of the regexp pattern.
+func exprMatch(params ...any) (any, error) {
+	re, s, ok, err := regexpArgs("match", params)
+	if err != nil || !ok {
+		return false, err
+	}
+	return re.MatchString(s), nil
+}
+
+// regex_find(pattern, s) returns the first capture group of the regexp pattern in s, or the match without groups.
+// It returns nil when s does not match. It is not named find, because find(array, predicate) is a builtin.
+func exprRegexFind(params ...any) (any, error) {
+	re, s, ok, err := regexpArgs("regex_find", params)
+	if err != nil || !ok {
+		return nil, err
+	}
+	m := re.FindStringSubmatch(s)
+	switch {
+	case m == nil:
+		return nil, nil
+	case len(m) > 1:
+		return m[1], nil
+	}
+	return m[0], nil
+}
+
+// regexps caches compiled patterns, because expressions of steps run many times with repeat and retry
+var regexps sync.Map
+
+// regexpArgs returns the compiled pattern and the string of s. s is not ok when it is nil.
+func regexpArgs(name string, params []any) (*regexp.Regexp, string, bool, error) {
+	if len(params) != 2 {
+		return nil, "", false, fmt.Errorf("%s: expected 2 arguments, got %d", name, len(params))
+	}
+	pattern, ok := params[0].(string)
+	if !ok {
+		return nil, "", false, fmt.Errorf("%s: pattern must be a string, got %T", name, params[0])
+	}
+	re, err := compileRegexp(pattern)
+	if err != nil {
+		return nil, "", false, fmt.Errorf("%s: invalid pattern %q: %w", name, pattern, err)
+	}
+	if params[1] == nil {
+		return re, "", false, nil
+	}
+	s, ok := AnyToString(params[1])
+	if !ok {
+		return nil, "", false, fmt.Errorf("%s: expected a string, got %T", name, params[1])
+	}
+	return re, s, true, nil
+}
+
+func compileRegexp(pattern string) (*regexp.Regexp, error) {
+	if re, ok := regexps.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	regexps.Store(pattern, re)
+	return re, nil
+}
+
+func jsonPair(name string, params []any) (map[string]any, map[string]any, error) {
+	if len(params) != 2 {
+		return nil, nil, fmt.Errorf("%s: expected 2 arguments, got %d", name, len(params))
+	}
+	src, ok := params[0].(map[string]any)
+	if !ok {
+		return nil, nil, fmt.Errorf("%s: expected a map, got %T", name, params[0])
+	}
+	target, ok := params[1].(map[string]any)
+	if !ok {
+		return nil, nil, fmt.Errorf("%s: expected a map, got %T", name, params[1])
+	}
+	return src, target, nil
+}
+
+// JSONPath returns the value at the path in v, where the path is keys joined by dots and indexes in brackets,
+// like "$.items[0].name" or "items[0]['content-type']". A json string of v is decoded.
+// It returns nil when the value is missing.
+func JSONPath(v any, path string) (any, error) {
+	if s, ok := v.(string); ok {
+		if err := json.Unmarshal([]byte(s), &v); err != nil {
+			return nil, err
+		}
+	}
+
+	p := strings.TrimPrefix(path, "$")
+	// the first key may be without the leading dot
+	if p != "" && p[0] != '.' && p[0] != '[' {
+		p = "." + p
+	}
+	for p != "" {
+		var key string
+		index := -1
+		switch {
+		case p[0] == '.':
+			p = p[1:]
+			end := strings.IndexAny(p, ".[")
+			if end < 0 {
+				end = len(p)
+			}
+			key, p = p[:end], p[end:]
+			if key == "" {
+				return nil, fmt.Errorf("empty key in path %q", path)
+			}
+		case p[0] == '[':
+			end := strings.IndexByte(p, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed bracket in path %q", path)
+			}
+			sel := p[1:end]
+			p = p[end+1:]
+			if len(sel) >= 2 && (sel[0] == '\'' || sel[0] == '"') && sel[len(sel)-1] == sel[0] {
+				key = sel[1 : len(sel)-1]
+			} else {
+				i, err := strconv.Atoi(sel)
+				if err != nil || i < 0 {
+					return nil, fmt.Errorf("invalid index %q in path %q", sel, path)
+				}
+				index = i
+			}
+		default:
+			return nil, fmt.Errorf("unexpected %q in path %q", p, path)
+		}
+
+		if index >= 0 {
+			arr, ok := v.([]any)
+			if !ok || index >= len(arr) {
+				return nil, nil
+			}
+			v = arr[index]
+			continue
+		}
+		m, ok := v.(map[string]any)
+		if !ok {
+			return nil, nil
+		}
+		if v, ok = m[key]; !ok {
+			return nil, nil
+		}
+	}
+
+	return v, nil
+}
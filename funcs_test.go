@@ -1,6 +1,7 @@
 package probe
 
 import (
+	"reflect"
 	"testing"
 )
 
@@ -184,3 +185,47 @@ func TestMatchJSON(t *testing.T) {
 		})
 	}
 }
+
+func TestJSONPath(t *testing.T) {
+	v := map[string]any{
+		"items": []any{
+			map[string]any{"name": "a", "tags": []any{"x", "y"}},
+		},
+		"headers": map[string]any{"content-type": "application/json"},
+	}
+
+	tests := []struct {
+		path     string
+		expected any
+		err      bool
+	}{
+		{path: "$.items[0].name", expected: "a"},
+		{path: "items[0].tags[1]", expected: "y"},
+		{path: "$.headers['content-type']", expected: "application/json"},
+		{path: `$["headers"].missing`, expected: nil},
+		{path: "$.items[3]", expected: nil},
+		{path: "$.items.name", expected: nil},
+		{path: "$", expected: v},
+		{path: "$.items[x]", err: true},
+		{path: "$.items[0", err: true},
+		{path: "$..name", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			actual, err := JSONPath(v, tt.path)
+			if tt.err {
+				if err == nil {
+					t.Errorf("expected an error, got %#v", actual)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error %s", err)
+			}
+			if !reflect.DeepEqual(tt.expected, actual) {
+				t.Errorf("expected %#v, got %#v", tt.expected, actual)
+			}
+		})
+	}
+}
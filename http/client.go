@@ -2,14 +2,34 @@ package http
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"mime"
+	"net"
 	hp "net/http"
+	"net/http/httptrace"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/linyows/probe"
+	"golang.org/x/net/http/httpproxy"
+	"golang.org/x/net/http2"
+)
+
+const (
+	HTTPVersion11              = "1.1"
+	HTTPVersion2               = "2"
+	HTTPVersion2PriorKnowledge = "2-prior-knowledge"
 )
 
 type TransportOptions struct {
@@ -17,20 +37,67 @@ type TransportOptions struct {
 	MaxIdleConns int `map:"max_idle_conns"`
 }
 
+// TLSOptions are a client certificate for mTLS and a ca to verify the server.
+type TLSOptions struct {
+	CertFile string `map:"cert_file"`
+	KeyFile  string `map:"key_file"`
+	CAFile   string `map:"ca_file"`
+}
+
 type Req struct {
-	URL    string            `map:"url" validate"required"`
-	Method string            `map:"method" validate:"required"`
-	Proto  string            `map:"ver"`
-	Header map[string]string `map:"headers"`
-	Body   []byte            `map:"body"`
-	cb     *Callback
+	URL              string            `map:"url" validate"required"`
+	Method           string            `map:"method" validate:"required"`
+	Proto            string            `map:"ver"`
+	HTTPVersion      string            `map:"http_version"`
+	Proxy            string            `map:"proxy"`
+	NoProxy          string            `map:"no_proxy"`
+	ProxyEnv         bool              `map:"proxy_env"`
+	TLS              TLSOptions        `map:"tls"`
+	OAuth2           OAuth2Options     `map:"oauth2"`
+	Paginate         PaginateOptions   `map:"paginate"`
+	LocalAddr        string            `map:"local_addr"`
+	DialTimeout      time.Duration     `map:"dial_timeout"`
+	KeepAlive        time.Duration     `map:"keepalive"`
+	DisableKeepAlive bool              `map:"disable_keepalive"`
+	Header           map[string]string `map:"headers"`
+	Body             []byte            `map:"body"`
+	BodyFile         string            `map:"body_file"`
+	BodyBase64       string            `map:"body_base64"`
+	Decompress       bool              `map:"decompress"`
+	Session          string            `map:"session"`
+	cb               *Callback
 }
 
+// Res holds the response. Headers are keyed by the lowercased names, and multiple values of a header are
+// joined with commas in headers, and kept by index in header_values. Raw headers are the status line and
+// the headers as received. The byte sizes are of http/1.1 messages, so they are approximate for http/2.
+// The body is decoded by the content-encoding unless decompress is false, and the response body size is
+// as received before decoding.
 type Res struct {
-	Status string            `map:"status"`
-	Code   int               `map:"code"`
-	Header map[string]string `map:"headers"`
-	Body   []byte            `map:"body"`
+	Status              string              `map:"status"`
+	Code                int                 `map:"code"`
+	Proto               string              `map:"proto"`
+	Header              map[string]string   `map:"headers"`
+	HeaderValues        map[string][]string `map:"header_values"`
+	RawHeaders          string              `map:"raw_headers"`
+	Cookies             map[string]string   `map:"cookies"`
+	Body                []byte              `map:"body"`
+	Proxy               string              `map:"proxy,omitempty"`
+	LocalAddr           string              `map:"local_addr,omitempty"`
+	OAuth2              OAuth2Token         `map:"oauth2"`
+	Items               []any               `map:"items,omitempty"`
+	Pages               int                 `map:"pages,omitempty"`
+	ConnectTime         time.Duration       `map:"connect_time"`
+	RT                  time.Duration       `map:"rt"`
+	Timing              Timing              `map:"timing"`
+	RequestBytes        int                 `map:"request_bytes"`
+	RequestHeaderBytes  int                 `map:"request_header_bytes"`
+	RequestBodyBytes    int                 `map:"request_body_bytes"`
+	ResponseBytes       int                 `map:"response_bytes"`
+	ResponseHeaderBytes int                 `map:"response_header_bytes"`
+	ResponseBodyBytes   int                 `map:"response_body_bytes"`
+	ContentEncoding     string              `map:"content_encoding,omitempty"`
+	DecodedBodyBytes    int                 `map:"decoded_body_bytes"`
 }
 
 type Result struct {
@@ -40,8 +107,10 @@ type Result struct {
 
 func NewReq() *Req {
 	return &Req{
-		Method: "GET",
-		Proto:  "HTTP/1.1",
+		Method:     "GET",
+		Proto:      "HTTP/1.1",
+		ProxyEnv:   true,
+		Decompress: true,
 		Header: map[string]string{
 			"Accept":     "*/*",
 			"User-Agent": "probe-http/1.0.0",
@@ -54,7 +123,12 @@ func (r *Req) Do() (*Result, error) {
 		return nil, errors.New("Req.URL is required")
 	}
 
-	req, err := hp.NewRequest(r.Method, r.URL, bytes.NewBuffer(r.Body))
+	body, err := r.body()
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := hp.NewRequest(r.Method, r.URL, bytes.NewBuffer(body))
 	if err != nil {
 		return nil, err
 	}
@@ -62,13 +136,55 @@ func (r *Req) Do() (*Result, error) {
 	for k, v := range r.Header {
 		req.Header.Set(probe.TitleCase(k, "-"), v)
 	}
+	if r.BodyFile != "" && req.Header.Get("Content-Type") == "" {
+		if ct := mime.TypeByExtension(filepath.Ext(r.BodyFile)); ct != "" {
+			req.Header.Set("Content-Type", ct)
+		}
+	}
+	if r.BodyBase64 != "" && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+	if req.Header.Get("Accept-Encoding") == "" {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+
+	tr, err := r.transport()
+	if err != nil {
+		return nil, err
+	}
+
+	var token OAuth2Token
+	if r.OAuth2.TokenURL != "" {
+		t, err := r.OAuth2.token(tr)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+t.accessToken)
+		token = t.metadata()
+	}
 
 	// callback
 	if r.cb != nil && r.cb.before != nil {
 		r.cb.before(req)
 	}
 
-	cl := &hp.Client{}
+	reqHeader, err := httputil.DumpRequestOut(req, false)
+	if err != nil {
+		return nil, err
+	}
+
+	proxy, err := r.proxy()(req.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	tc := newTracer()
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), tc.clientTrace()))
+
+	cl := &hp.Client{Transport: tr}
+	if r.Session != "" {
+		cl.Jar = sessionJar(r.Session)
+	}
 	res, err := cl.Do(req)
 	if err != nil {
 		return nil, err
@@ -80,28 +196,247 @@ func (r *Req) Do() (*Result, error) {
 		r.cb.after(res)
 	}
 
-	body, err := ioutil.ReadAll(res.Body)
+	resBody, err := ioutil.ReadAll(res.Body)
 	if err != nil {
 		return nil, err
 	}
+	timing, localAddr := tc.done()
+
+	resHeader, err := httputil.DumpResponse(res, false)
+	if err != nil {
+		return nil, err
+	}
+
+	// the raw body is kept for inspection when decompress is false
+	encoding := res.Header.Get("Content-Encoding")
+	decodedBody, decodeErr := decodeBody(encoding, resBody)
+	if decodeErr != nil && r.Decompress {
+		return nil, decodeErr
+	}
+	retBody := resBody
+	if r.Decompress {
+		retBody = decodedBody
+	}
+
+	// header names are lowercased, so that they are accessed like `res.headers["content-type"]`
+	cookies := make(map[string]string)
+	for _, c := range res.Cookies() {
+		cookies[c.Name] = c.Value
+	}
 
 	header := make(map[string]string)
+	headerValues := make(map[string][]string)
 	for k, v := range res.Header {
 		// examples:
 		//   Set-Cookie: sessionid=abc123; Path=/; HttpOnly
 		//   Accept: text/html, application/xhtml+xml, application/xml;q=0.9
-		header[k] = strings.Join(v, ", ")
+		name := strings.ToLower(k)
+		header[name] = strings.Join(v, ", ")
+		headerValues[name] = v
 	}
 
-	return &Result{
-		Req: *r,
+	// the client secret is not echoed in the result of the request
+	echo := *r
+	echo.OAuth2.ClientSecret = ""
+	ret := &Result{
+		Req: echo,
 		Res: Res{
-			Status: res.Status,
-			Code:   res.StatusCode,
-			Header: header,
-			Body:   body,
+			Status:              res.Status,
+			Code:                res.StatusCode,
+			Proto:               res.Proto,
+			Header:              header,
+			HeaderValues:        headerValues,
+			RawHeaders:          strings.TrimRight(string(resHeader), "\r\n"),
+			Cookies:             cookies,
+			Body:                retBody,
+			RequestBytes:        len(reqHeader) + len(body),
+			RequestHeaderBytes:  len(reqHeader),
+			RequestBodyBytes:    len(body),
+			ResponseBytes:       len(resHeader) + len(resBody),
+			ResponseHeaderBytes: len(resHeader),
+			ResponseBodyBytes:   len(resBody),
+			ContentEncoding:     encoding,
+			DecodedBodyBytes:    len(decodedBody),
 		},
-	}, nil
+	}
+	if proxy != nil {
+		ret.Res.Proxy = proxy.Redacted()
+	}
+	ret.Res.OAuth2 = token
+	ret.Res.LocalAddr = localAddr
+	ret.Res.ConnectTime = timing.Connect
+	ret.Res.RT = timing.Total
+	ret.Res.Timing = timing
+
+	// the first page failing is left to the test of the step
+	if r.Paginate.Items != "" && res.StatusCode < 300 {
+		if decodeErr != nil {
+			return nil, decodeErr
+		}
+		if err := r.Paginate.follow(cl, req, body, decodedBody, res.Header, &ret.Res); err != nil {
+			return nil, err
+		}
+	}
+
+	return ret, nil
+}
+
+// body returns the body of the request, which is read from body_file or decoded from body_base64 when it is given.
+// Line breaks in body_base64 are ignored, so that it can be folded in yaml.
+func (r *Req) body() ([]byte, error) {
+	var given []string
+	if len(r.Body) > 0 {
+		given = append(given, "body")
+	}
+	if r.BodyFile != "" {
+		given = append(given, "body_file")
+	}
+	if r.BodyBase64 != "" {
+		given = append(given, "body_base64")
+	}
+	if len(given) > 1 {
+		return nil, fmt.Errorf("%s cannot be given together", strings.Join(given, " and "))
+	}
+
+	switch {
+	case r.BodyFile != "":
+		b, err := os.ReadFile(r.BodyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read body_file: %w", err)
+		}
+		return b, nil
+	case r.BodyBase64 != "":
+		b, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(r.BodyBase64), ""))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode body_base64: %w", err)
+		}
+		return b, nil
+	}
+	return r.Body, nil
+}
+
+// transport returns the transport for the http version. The default transport negotiates the version,
+// `1.1` disables http/2, `2` requires http/2 over tls, and `2-prior-knowledge` speaks http/2 over cleartext.
+// dial_timeout and keepalive are of the tcp connection, and disable_keepalive stops reusing http/1.1 connections.
+func (r *Req) transport() (hp.RoundTripper, error) {
+	tlsConfig, err := r.TLS.config()
+	if err != nil {
+		return nil, err
+	}
+
+	d := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	if r.DialTimeout > 0 {
+		d.Timeout = r.DialTimeout
+	}
+	if r.KeepAlive != 0 {
+		d.KeepAlive = r.KeepAlive
+	}
+	if r.LocalAddr != "" {
+		local, err := probe.LocalAddr("tcp", r.LocalAddr)
+		if err != nil {
+			return nil, err
+		}
+		d.LocalAddr = local
+	}
+
+	switch r.HTTPVersion {
+	case "", HTTPVersion11:
+		if r.HTTPVersion == "" && r.Proxy == "" && r.NoProxy == "" && r.ProxyEnv && tlsConfig == nil && r.LocalAddr == "" &&
+			r.DialTimeout == 0 && r.KeepAlive == 0 && !r.DisableKeepAlive {
+			return hp.DefaultTransport, nil
+		}
+		tr := hp.DefaultTransport.(*hp.Transport).Clone()
+		tr.DialContext = d.DialContext
+		tr.DisableKeepAlives = r.DisableKeepAlive
+		proxy := r.proxy()
+		tr.Proxy = func(req *hp.Request) (*url.URL, error) {
+			return proxy(req.URL)
+		}
+		if tlsConfig != nil {
+			tr.TLSClientConfig = tlsConfig
+		}
+		if r.HTTPVersion == HTTPVersion11 {
+			tr.ForceAttemptHTTP2 = false
+			tr.TLSNextProto = map[string]func(string, *tls.Conn) hp.RoundTripper{}
+		}
+		return tr, nil
+	}
+
+	if r.Proxy != "" {
+		return nil, fmt.Errorf("proxy is not supported with http_version %s", r.HTTPVersion)
+	}
+
+	switch r.HTTPVersion {
+	case HTTPVersion2:
+		return &http2.Transport{
+			TLSClientConfig: tlsConfig,
+			DialTLSContext: func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+				td := &tls.Dialer{NetDialer: d, Config: cfg}
+				return td.DialContext(ctx, network, addr)
+			},
+		}, nil
+	case HTTPVersion2PriorKnowledge:
+		return &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				return d.DialContext(ctx, network, addr)
+			},
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported http_version: %s", r.HTTPVersion)
+	}
+}
+
+// config returns the tls config for the options, or nil when no options are given.
+func (o TLSOptions) config() (*tls.Config, error) {
+	if o.CertFile == "" && o.KeyFile == "" && o.CAFile == "" {
+		return nil, nil
+	}
+
+	cfg := &tls.Config{}
+	if o.CertFile != "" || o.KeyFile != "" {
+		if o.CertFile == "" || o.KeyFile == "" {
+			return nil, errors.New("tls cert_file and key_file must be given together")
+		}
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	if o.CAFile != "" {
+		pem, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ca file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in ca file: %s", o.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+
+	return cfg, nil
+}
+
+// proxy returns the function choosing the proxy for a url. The proxy param is used first, and then
+// the proxy environment variables unless proxy_env is false. Hosts in no_proxy are never proxied.
+func (r *Req) proxy() func(*url.URL) (*url.URL, error) {
+	var cfg *httpproxy.Config
+	switch {
+	case r.Proxy != "":
+		cfg = &httpproxy.Config{HTTPProxy: r.Proxy, HTTPSProxy: r.Proxy, NoProxy: r.NoProxy}
+	case r.ProxyEnv:
+		cfg = httpproxy.FromEnvironment()
+		if r.NoProxy != "" {
+			cfg.NoProxy = strings.Trim(cfg.NoProxy+","+r.NoProxy, ",")
+		}
+	default:
+		return func(*url.URL) (*url.URL, error) { return nil, nil }
+	}
+
+	return cfg.ProxyFunc()
 }
 
 type Option func(*Callback)
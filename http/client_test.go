@@ -1,19 +1,47 @@
 package http
 
 import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	hp "net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/andybalholm/brotli"
 	"github.com/jarcoal/httpmock"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
 func TestNewReq(t *testing.T) {
 	got := NewReq()
 
 	expects := &Req{
-		URL:    "",
-		Method: "GET",
-		Proto:  "HTTP/1.1",
+		URL:        "",
+		Method:     "GET",
+		Proto:      "HTTP/1.1",
+		ProxyEnv:   true,
+		Decompress: true,
 		Header: map[string]string{
 			"Accept":     "*/*",
 			"User-Agent": "probe-http/1.0.0",
@@ -46,3 +74,744 @@ func TestDo(t *testing.T) {
 		t.Errorf("\nExpected:\n%s\nGot:\n%s", expects, got.Res.Body)
 	}
 }
+
+func TestDoHTTPVersion(t *testing.T) {
+	handler := hp.HandlerFunc(func(w hp.ResponseWriter, r *hp.Request) {
+		w.Write([]byte(r.Proto))
+	})
+	ts := httptest.NewServer(h2c.NewHandler(handler, &http2.Server{}))
+	defer ts.Close()
+
+	tests := []struct {
+		version string
+		proto   string
+	}{
+		{"", "HTTP/1.1"},
+		{HTTPVersion11, "HTTP/1.1"},
+		{HTTPVersion2PriorKnowledge, "HTTP/2.0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			req := NewReq()
+			req.URL = ts.URL
+			req.HTTPVersion = tt.version
+
+			got, err := req.Do()
+			if err != nil {
+				t.Fatalf("got error %s", err)
+			}
+			if got.Res.Proto != tt.proto || string(got.Res.Body) != tt.proto {
+				t.Errorf("expected %s, got %s (server: %s)", tt.proto, got.Res.Proto, got.Res.Body)
+			}
+		})
+	}
+}
+
+func TestDoUnsupportedHTTPVersion(t *testing.T) {
+	req := NewReq()
+	req.URL = "http://localhost:8080"
+	req.HTTPVersion = "3"
+
+	if _, err := req.Do(); err == nil || err.Error() != "unsupported http_version: 3" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestDoBytes(t *testing.T) {
+	ts := httptest.NewServer(hp.HandlerFunc(func(w hp.ResponseWriter, r *hp.Request) {
+		w.Header().Set("X-Padding", "0123456789")
+		w.Write([]byte("hello"))
+	}))
+	defer ts.Close()
+
+	req := NewReq()
+	req.URL = ts.URL
+	req.Method = "POST"
+	req.Body = []byte("name=alice")
+
+	got, err := req.Do()
+	if err != nil {
+		t.Fatalf("got error %s", err)
+	}
+
+	res := got.Res
+	if res.RequestBytes != res.RequestHeaderBytes+10 {
+		t.Errorf("expected request bytes to be header + 10, got %d and %d", res.RequestBytes, res.RequestHeaderBytes)
+	}
+	if res.ResponseBytes != res.ResponseHeaderBytes+5 {
+		t.Errorf("expected response bytes to be header + 5, got %d and %d", res.ResponseBytes, res.ResponseHeaderBytes)
+	}
+	// at least the status line and the padding header
+	if min := len("HTTP/1.1 200 OK\r\nX-Padding: 0123456789\r\n"); res.ResponseHeaderBytes < min {
+		t.Errorf("expected response header bytes to be at least %d, got %d", min, res.ResponseHeaderBytes)
+	}
+}
+
+func TestDoBodyFile(t *testing.T) {
+	ts := httptest.NewServer(hp.HandlerFunc(func(w hp.ResponseWriter, r *hp.Request) {
+		b, _ := io.ReadAll(r.Body)
+		w.Write([]byte(r.Header.Get("Content-Type") + " " + string(b)))
+	}))
+	defer ts.Close()
+
+	path := filepath.Join(t.TempDir(), "user.json")
+	os.WriteFile(path, []byte(`{"name":"alice"}`), 0o600)
+
+	tests := []struct {
+		name     string
+		header   map[string]string
+		expected string
+	}{
+		{"inferred content type", map[string]string{}, `application/json {"name":"alice"}`},
+		{"given content type", map[string]string{"content-type": "text/plain"}, `text/plain {"name":"alice"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := NewReq()
+			req.URL = ts.URL
+			req.Method = "POST"
+			req.Header = tt.header
+			req.BodyFile = path
+
+			got, err := req.Do()
+			if err != nil {
+				t.Fatalf("got error %s", err)
+			}
+			if string(got.Res.Body) != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, got.Res.Body)
+			}
+			if got.Res.RequestBodyBytes != 16 {
+				t.Errorf("expected request body bytes 16, got %d", got.Res.RequestBodyBytes)
+			}
+		})
+	}
+
+	req := NewReq()
+	req.URL = ts.URL
+	req.Body = []byte("name=alice")
+	req.BodyFile = path
+	if _, err := req.Do(); err == nil || err.Error() != "body and body_file cannot be given together" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestDoBodyBase64(t *testing.T) {
+	ts := httptest.NewServer(hp.HandlerFunc(func(w hp.ResponseWriter, r *hp.Request) {
+		b, _ := io.ReadAll(r.Body)
+		w.Write([]byte(fmt.Sprintf("%s %x", r.Header.Get("Content-Type"), b)))
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		header   map[string]string
+		body     string
+		expected string
+	}{
+		{"default content type", map[string]string{}, "CAESBWFsaWNl", "application/octet-stream 08011205616c696365"},
+		{"given content type", map[string]string{"content-type": "application/x-protobuf"}, "CAESBWFs\naWNl\n", "application/x-protobuf 08011205616c696365"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := NewReq()
+			req.URL = ts.URL
+			req.Method = "POST"
+			req.Header = tt.header
+			req.BodyBase64 = tt.body
+
+			got, err := req.Do()
+			if err != nil {
+				t.Fatalf("got error %s", err)
+			}
+			if string(got.Res.Body) != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, got.Res.Body)
+			}
+			if got.Res.RequestBodyBytes != 9 {
+				t.Errorf("expected request body bytes 9, got %d", got.Res.RequestBodyBytes)
+			}
+		})
+	}
+
+	req := NewReq()
+	req.URL = ts.URL
+	req.Body = []byte("name=alice")
+	req.BodyBase64 = "CAESBWFsaWNl"
+	if _, err := req.Do(); err == nil || err.Error() != "body and body_base64 cannot be given together" {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	req = NewReq()
+	req.URL = ts.URL
+	req.BodyBase64 = "!"
+	if _, err := req.Do(); err == nil || !strings.HasPrefix(err.Error(), "failed to decode body_base64") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestDoDecompress(t *testing.T) {
+	plain := strings.Repeat(`{"name":"alice"}`, 10)
+	encode := func(encoding string) []byte {
+		var b bytes.Buffer
+		var w io.WriteCloser
+		switch encoding {
+		case "gzip":
+			w = gzip.NewWriter(&b)
+		case "deflate":
+			w = zlib.NewWriter(&b)
+		case "br":
+			w = brotli.NewWriter(&b)
+		default:
+			return []byte(plain)
+		}
+		w.Write([]byte(plain))
+		w.Close()
+		return b.Bytes()
+	}
+
+	var accepted string
+	ts := httptest.NewServer(hp.HandlerFunc(func(w hp.ResponseWriter, r *hp.Request) {
+		accepted = r.Header.Get("Accept-Encoding")
+		encoding := r.URL.Query().Get("encoding")
+		if encoding != "" {
+			w.Header().Set("Content-Encoding", encoding)
+		}
+		w.Write(encode(encoding))
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		encoding   string
+		decompress bool
+	}{
+		{"", true},
+		{"gzip", true},
+		{"deflate", true},
+		{"br", true},
+		{"br", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s decompress %t", tt.encoding, tt.decompress), func(t *testing.T) {
+			req := NewReq()
+			req.URL = ts.URL + "?encoding=" + tt.encoding
+			req.Decompress = tt.decompress
+
+			got, err := req.Do()
+			if err != nil {
+				t.Fatalf("got error %s", err)
+			}
+			if accepted != "gzip, deflate, br" {
+				t.Errorf("unexpected accept-encoding %q", accepted)
+			}
+
+			res := got.Res
+			encoded := encode(tt.encoding)
+			expected := plain
+			if !tt.decompress {
+				expected = string(encoded)
+			}
+			if string(res.Body) != expected {
+				t.Errorf("expected body %q, got %q", expected, res.Body)
+			}
+			if res.ContentEncoding != tt.encoding {
+				t.Errorf("expected content encoding %q, got %q", tt.encoding, res.ContentEncoding)
+			}
+			if res.ResponseBodyBytes != len(encoded) || res.DecodedBodyBytes != len(plain) {
+				t.Errorf("expected body bytes %d and decoded %d, got %d and %d",
+					len(encoded), len(plain), res.ResponseBodyBytes, res.DecodedBodyBytes)
+			}
+		})
+	}
+
+	req := NewReq()
+	req.URL = ts.URL + "?encoding=gzip"
+	req.Header["Accept-Encoding"] = "identity"
+	if _, err := req.Do(); err != nil {
+		t.Fatalf("got error %s", err)
+	}
+	if accepted != "identity" {
+		t.Errorf("expected the given accept-encoding, got %q", accepted)
+	}
+}
+
+func TestDoOAuth2(t *testing.T) {
+	var fetched int
+	tokenServer := httptest.NewServer(hp.HandlerFunc(func(w hp.ResponseWriter, r *hp.Request) {
+		id, secret, _ := r.BasicAuth()
+		r.ParseForm()
+		if id != "probe" || secret != "s3cret" || r.Form.Get("grant_type") != "client_credentials" || r.Form.Get("scope") != "read" {
+			w.WriteHeader(hp.StatusUnauthorized)
+			w.Write([]byte(`{"error":"invalid_client"}`))
+			return
+		}
+		fetched++
+		w.Header().Set("Content-Type", "application/json")
+		// a token expiring within the delta is fetched every time
+		expiresIn := 3600
+		if r.URL.Path == "/short" {
+			expiresIn = 5
+		}
+		w.Write([]byte(fmt.Sprintf(`{"access_token":"token-%d","token_type":"bearer","expires_in":%d}`, fetched, expiresIn)))
+	}))
+	defer tokenServer.Close()
+
+	ts := httptest.NewServer(hp.HandlerFunc(func(w hp.ResponseWriter, r *hp.Request) {
+		w.Write([]byte(r.Header.Get("Authorization")))
+	}))
+	defer ts.Close()
+
+	do := func(path, secret string) (*Result, error) {
+		req := NewReq()
+		req.URL = ts.URL
+		req.OAuth2 = OAuth2Options{TokenURL: tokenServer.URL + path, ClientID: "probe", ClientSecret: secret, Scope: "read"}
+		return req.Do()
+	}
+
+	for i, expected := range []struct {
+		auth   string
+		cached bool
+	}{{"Bearer token-1", false}, {"Bearer token-1", true}} {
+		got, err := do("/token", "s3cret")
+		if err != nil {
+			t.Fatalf("got error %s", err)
+		}
+		if string(got.Res.Body) != expected.auth || got.Res.OAuth2.Cached != expected.cached || got.Res.OAuth2.ExpiresAt == "" {
+			t.Errorf("request %d: expected %s (cached %t), got %s %#v", i, expected.auth, expected.cached, got.Res.Body, got.Res.OAuth2)
+		}
+		if got.Req.OAuth2.ClientSecret != "" {
+			t.Errorf("request %d: expected the client secret not to be echoed, got %q", i, got.Req.OAuth2.ClientSecret)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := do("/short", "s3cret"); err != nil {
+			t.Fatalf("got error %s", err)
+		}
+	}
+	if fetched != 3 {
+		t.Errorf("expected 3 token requests, got %d", fetched)
+	}
+
+	_, err := do("/invalid", "wrong")
+	if err == nil || !strings.Contains(err.Error(), "401 Unauthorized") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestDoPaginate(t *testing.T) {
+	var ts *httptest.Server
+	ts = httptest.NewServer(hp.HandlerFunc(func(w hp.ResponseWriter, r *hp.Request) {
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		switch r.URL.Path {
+		case "/body":
+			next := ""
+			if page < 3 {
+				next = fmt.Sprintf("/body?page=%d", page+1)
+			}
+			fmt.Fprintf(w, `{"data":[{"id":%d},{"id":%d}],"links":{"next":"%s"}}`, page*2-1, page*2, next)
+		case "/link":
+			if page < 3 {
+				w.Header().Set("Link", fmt.Sprintf(`<%s/link?page=%d>; rel="next", <%s/link?page=1>; rel="first"`, ts.URL, page+1, ts.URL))
+			}
+			fmt.Fprintf(w, `[%d]`, page)
+		case "/error":
+			if page > 1 {
+				// an error page of a proxy is not encoded as it claims
+				w.Header().Set("Content-Encoding", "gzip")
+				w.WriteHeader(hp.StatusBadGateway)
+				w.Write([]byte("bad gateway"))
+				return
+			}
+			w.Header().Set("Link", fmt.Sprintf(`<%s/error?page=2>; rel="next"`, ts.URL))
+			fmt.Fprint(w, `[1]`)
+		}
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		path     string
+		paginate PaginateOptions
+		pages    int
+		items    []any
+	}{
+		{"next in body", "/body?page=1", PaginateOptions{Items: "data", Next: "links.next"}, 3,
+			[]any{map[string]any{"id": 1.0}, map[string]any{"id": 2.0}, map[string]any{"id": 3.0}, map[string]any{"id": 4.0}, map[string]any{"id": 5.0}, map[string]any{"id": 6.0}}},
+		{"link header", "/link?page=1", PaginateOptions{Items: "."}, 3, []any{1.0, 2.0, 3.0}},
+		{"max pages", "/link?page=1", PaginateOptions{Items: ".", MaxPages: 2}, 2, []any{1.0, 2.0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := NewReq()
+			req.URL = ts.URL + tt.path
+			req.Paginate = tt.paginate
+
+			got, err := req.Do()
+			if err != nil {
+				t.Fatalf("got error %s", err)
+			}
+			if got.Res.Pages != tt.pages || !reflect.DeepEqual(got.Res.Items, tt.items) {
+				t.Errorf("expected %d pages %v, got %d pages %v", tt.pages, tt.items, got.Res.Pages, got.Res.Items)
+			}
+		})
+	}
+
+	req := NewReq()
+	req.URL = ts.URL + "/body?page=1"
+	req.Paginate = PaginateOptions{Items: "users"}
+	if _, err := req.Do(); err == nil || err.Error() != "paginate: items 'users' is not an array in page 1" {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	req = NewReq()
+	req.URL = ts.URL + "/error?page=1"
+	req.Paginate = PaginateOptions{Items: "."}
+	if _, err := req.Do(); err == nil || err.Error() != "paginate: page 2 responded with 502 Bad Gateway" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestDoLocalAddr(t *testing.T) {
+	handler := hp.HandlerFunc(func(w hp.ResponseWriter, r *hp.Request) {
+		w.Write([]byte(r.RemoteAddr))
+	})
+	ts := httptest.NewServer(h2c.NewHandler(handler, &http2.Server{}))
+	defer ts.Close()
+
+	for _, version := range []string{"", HTTPVersion2PriorKnowledge} {
+		req := NewReq()
+		req.URL = ts.URL
+		req.HTTPVersion = version
+		req.LocalAddr = "127.0.0.1"
+
+		got, err := req.Do()
+		if err != nil {
+			t.Fatalf("got error %s", err)
+		}
+		if got.Res.LocalAddr == "" || got.Res.LocalAddr != string(got.Res.Body) {
+			t.Errorf("expected local address %s, got %s", got.Res.Body, got.Res.LocalAddr)
+		}
+	}
+}
+
+func TestDoConnectTime(t *testing.T) {
+	ts := httptest.NewServer(hp.HandlerFunc(func(w hp.ResponseWriter, r *hp.Request) {
+		w.Write([]byte(strconv.FormatBool(r.Close)))
+	}))
+	defer ts.Close()
+
+	for _, disable := range []bool{false, true} {
+		req := NewReq()
+		req.URL = ts.URL
+		req.DialTimeout = time.Second
+		req.KeepAlive = 10 * time.Second
+		req.DisableKeepAlive = disable
+
+		got, err := req.Do()
+		if err != nil {
+			t.Fatalf("got error %s", err)
+		}
+		if string(got.Res.Body) != strconv.FormatBool(disable) {
+			t.Errorf("disable_keepalive %t: expected the connection close %t, got %s", disable, disable, got.Res.Body)
+		}
+		if got.Res.ConnectTime <= 0 || got.Res.RT < got.Res.ConnectTime {
+			t.Errorf("unexpected connect time %s and rt %s", got.Res.ConnectTime, got.Res.RT)
+		}
+	}
+}
+
+func TestDoTiming(t *testing.T) {
+	// a certificate for localhost, so that the request resolves the name
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(2),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, _ := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	caFile := filepath.Join(t.TempDir(), "ca.crt")
+	os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+
+	ts := httptest.NewUnstartedServer(hp.HandlerFunc(func(w hp.ResponseWriter, r *hp.Request) {
+		time.Sleep(10 * time.Millisecond)
+		w.Write([]byte("ok"))
+	}))
+	ts.TLS = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	ts.StartTLS()
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL)
+	req := NewReq()
+	req.URL = "https://localhost:" + u.Port()
+	req.TLS = TLSOptions{CAFile: caFile}
+
+	got, err := req.Do()
+	if err != nil {
+		t.Fatalf("got error %s", err)
+	}
+
+	tm := got.Res.Timing
+	if tm.DNS <= 0 || tm.Connect <= 0 || tm.TLS <= 0 || tm.TTFB < 10*time.Millisecond || tm.Total < tm.TTFB {
+		t.Errorf("expected all phases populated, got %#v", tm)
+	}
+	if got.Res.RT != tm.Total || got.Res.ConnectTime != tm.Connect {
+		t.Errorf("expected rt and connect_time to be of the timing, got %s and %s", got.Res.RT, got.Res.ConnectTime)
+	}
+}
+
+func TestTracerParallelDials(t *testing.T) {
+	tc := newTracer()
+	trace := tc.clientTrace()
+
+	// happy eyeballs dials ipv6 and ipv4 at the same time, and one of them fails
+	var wg sync.WaitGroup
+	for _, dial := range []struct {
+		addr string
+		err  error
+	}{{"[::1]:443", errors.New("connection refused")}, {"127.0.0.1:443", nil}} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			trace.ConnectStart("tcp", dial.addr)
+			time.Sleep(time.Millisecond)
+			trace.ConnectDone("tcp", dial.addr, dial.err)
+		}()
+	}
+	wg.Wait()
+
+	timing, _ := tc.done()
+	if timing.Connect <= 0 || timing.Total < timing.Connect {
+		t.Errorf("expected the connect time of the established dial, got %#v", timing)
+	}
+}
+
+func TestRequestHeaders(t *testing.T) {
+	ts := httptest.NewServer(hp.HandlerFunc(func(w hp.ResponseWriter, r *hp.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Add("Set-Cookie", "session=abc; Path=/")
+		w.Header().Add("Set-Cookie", "theme=dark")
+		w.Write([]byte(`{}`))
+	}))
+	defer ts.Close()
+
+	got, err := Request(map[string]string{"url": ts.URL, "method": "GET"})
+	if err != nil {
+		t.Fatalf("got error %s", err)
+	}
+
+	expects := map[string]string{
+		"res__headers__content-type":        "application/json",
+		"res__headers__set-cookie":          "session=abc; Path=/, theme=dark",
+		"res__header_values__set-cookie__0": "session=abc; Path=/",
+		"res__header_values__set-cookie__1": "theme=dark",
+	}
+	for k, v := range expects {
+		if got[k] != v {
+			t.Errorf("expected %s to be %q, got %q", k, v, got[k])
+		}
+	}
+	if raw := got["res__raw_headers"]; !strings.HasPrefix(raw, "HTTP/1.1 200 OK\r\n") || !strings.Contains(raw, "Set-Cookie: theme=dark") {
+		t.Errorf("unexpected raw headers %q", raw)
+	}
+}
+
+func TestDoSession(t *testing.T) {
+	ts := httptest.NewServer(hp.HandlerFunc(func(w hp.ResponseWriter, r *hp.Request) {
+		if r.URL.Path == "/login" {
+			hp.SetCookie(w, &hp.Cookie{Name: "sid", Value: "abc123", Path: "/"})
+			return
+		}
+		c, err := r.Cookie("sid")
+		if err != nil {
+			w.WriteHeader(hp.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(c.Value))
+	}))
+	defer ts.Close()
+
+	do := func(path, session string) *Result {
+		req := NewReq()
+		req.URL = ts.URL + path
+		req.Session = session
+		got, err := req.Do()
+		if err != nil {
+			t.Fatalf("got error %s", err)
+		}
+		return got
+	}
+
+	login := do("/login", "alice")
+	if login.Res.Cookies["sid"] != "abc123" {
+		t.Errorf("expected the received cookie, got %#v", login.Res.Cookies)
+	}
+	if got := do("/me", "alice"); got.Res.Code != 200 || string(got.Res.Body) != "abc123" {
+		t.Errorf("expected the cookie to be sent in the session, got %d %s", got.Res.Code, got.Res.Body)
+	}
+	if got := do("/me", "bob"); got.Res.Code != 401 {
+		t.Errorf("expected no cookie in another session, got %d", got.Res.Code)
+	}
+	if got := do("/me", ""); got.Res.Code != 401 {
+		t.Errorf("expected no cookie without session, got %d", got.Res.Code)
+	}
+}
+
+func TestDoProxy(t *testing.T) {
+	var auth string
+	proxy := httptest.NewServer(hp.HandlerFunc(func(w hp.ResponseWriter, r *hp.Request) {
+		auth = r.Header.Get("Proxy-Authorization")
+		w.Write([]byte("via proxy " + r.URL.String()))
+	}))
+	defer proxy.Close()
+
+	u, _ := url.Parse(proxy.URL)
+	u.User = url.UserPassword("alice", "secret")
+
+	req := NewReq()
+	req.URL = "http://api.example.test/users"
+	req.Proxy = u.String()
+
+	got, err := req.Do()
+	if err != nil {
+		t.Fatalf("got error %s", err)
+	}
+	if string(got.Res.Body) != "via proxy http://api.example.test/users" {
+		t.Errorf("expected the request via proxy, got %s", got.Res.Body)
+	}
+	if auth == "" {
+		t.Error("expected Proxy-Authorization header")
+	}
+	if expected := u.Redacted(); got.Res.Proxy != expected {
+		t.Errorf("expected proxy %s, got %s", expected, got.Res.Proxy)
+	}
+}
+
+func TestProxy(t *testing.T) {
+	t.Setenv("HTTP_PROXY", "http://env-proxy:8080")
+	t.Setenv("NO_PROXY", "")
+
+	tests := []struct {
+		name     string
+		req      Req
+		url      string
+		expected string
+	}{
+		{"param", Req{Proxy: "http://proxy:3128", ProxyEnv: true}, "http://example.test", "http://proxy:3128"},
+		{"param with no_proxy", Req{Proxy: "http://proxy:3128", NoProxy: "internal.test"}, "http://api.internal.test", ""},
+		{"env", Req{ProxyEnv: true}, "http://example.test", "http://env-proxy:8080"},
+		{"env with no_proxy", Req{ProxyEnv: true, NoProxy: "example.test"}, "http://example.test", ""},
+		{"env disabled", Req{}, "http://example.test", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, _ := url.Parse(tt.url)
+			got, err := tt.req.proxy()(u)
+			if err != nil {
+				t.Fatalf("got error %s", err)
+			}
+			if (got == nil && tt.expected != "") || (got != nil && got.String() != tt.expected) {
+				t.Errorf("expected %q, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+// writeClientCert writes a self-signed client certificate and its key, and returns the paths and the certificate.
+func writeClientCert(t *testing.T) (string, string, *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "probe"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "client.crt")
+	keyFile := filepath.Join(dir, "client.key")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600)
+
+	return certFile, keyFile, cert
+}
+
+func TestDoMutualTLS(t *testing.T) {
+	certFile, keyFile, clientCert := writeClientCert(t)
+
+	ts := httptest.NewUnstartedServer(hp.HandlerFunc(func(w hp.ResponseWriter, r *hp.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	pool := x509.NewCertPool()
+	pool.AddCert(clientCert)
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: pool}
+	ts.StartTLS()
+	defer ts.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.crt")
+	os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}), 0o600)
+
+	req := NewReq()
+	req.URL = ts.URL
+	req.TLS = TLSOptions{CertFile: certFile, KeyFile: keyFile, CAFile: caFile}
+
+	got, err := req.Do()
+	if err != nil {
+		t.Fatalf("got error %s", err)
+	}
+	if string(got.Res.Body) != "probe" {
+		t.Errorf("expected the client certificate to be sent, got %s", got.Res.Body)
+	}
+
+	// without the client certificate
+	req.TLS = TLSOptions{CAFile: caFile}
+	if _, err := req.Do(); err == nil {
+		t.Error("expected error without the client certificate")
+	}
+}
+
+func TestTLSOptionsError(t *testing.T) {
+	certFile, _, _ := writeClientCert(t)
+
+	tests := []struct {
+		name     string
+		opts     TLSOptions
+		expected string
+	}{
+		{"cert without key", TLSOptions{CertFile: certFile}, "tls cert_file and key_file must be given together"},
+		{"mismatched pair", TLSOptions{CertFile: certFile, KeyFile: certFile}, "failed to load client certificate"},
+		{"invalid ca", TLSOptions{CAFile: os.DevNull}, "no certificates found in ca file"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.opts.config(); err == nil || !strings.HasPrefix(err.Error(), tt.expected) {
+				t.Errorf("expected error %q, got %v", tt.expected, err)
+			}
+		})
+	}
+}
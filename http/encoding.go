@@ -0,0 +1,52 @@
+package http
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// acceptEncoding is sent unless the accept-encoding header is given, so that the transport
+// does not decompress gzip by itself and the encoded size is known.
+const acceptEncoding = "gzip, deflate, br"
+
+// decodeBody decodes the body by the content-encoding, where multiple encodings are applied in order.
+// Unknown encodings are left as they are.
+func decodeBody(encoding string, b []byte) ([]byte, error) {
+	// responses to head requests have no body with the content-encoding
+	if len(b) == 0 {
+		return b, nil
+	}
+	codings := strings.Split(encoding, ",")
+	for i := len(codings) - 1; i >= 0; i-- {
+		coding := strings.ToLower(strings.TrimSpace(codings[i]))
+		var r io.Reader
+		var err error
+		switch coding {
+		case "gzip", "x-gzip":
+			r, err = gzip.NewReader(bytes.NewReader(b))
+		case "deflate":
+			// deflate is zlib wrapped by the spec, but some servers send the raw deflate
+			if r, err = zlib.NewReader(bytes.NewReader(b)); err != nil {
+				r, err = flate.NewReader(bytes.NewReader(b)), nil
+			}
+		case "br":
+			r = brotli.NewReader(bytes.NewReader(b))
+		default:
+			continue
+		}
+		if err == nil {
+			b, err = io.ReadAll(r)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode %s response body: %w", coding, err)
+		}
+	}
+	return b, nil
+}
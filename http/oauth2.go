@@ -0,0 +1,126 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	hp "net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tokens are renewed this much before they expire
+const oauth2ExpiryDelta = 10 * time.Second
+
+// OAuth2Options fetch a token from the token url by the client credentials grant,
+// and the token is sent as the bearer token of the request.
+type OAuth2Options struct {
+	TokenURL     string `map:"token_url"`
+	ClientID     string `map:"client_id"`
+	ClientSecret string `map:"client_secret"`
+	Scope        string `map:"scope"`
+}
+
+// OAuth2Token is the metadata of the token sent, for debugging.
+type OAuth2Token struct {
+	TokenType string `map:"token_type,omitempty"`
+	ExpiresAt string `map:"expires_at,omitempty"`
+	Cached    bool   `map:"cached,omitempty"`
+}
+
+type oauth2Token struct {
+	accessToken string
+	tokenType   string
+	expiresAt   time.Time
+	cached      bool
+}
+
+func (t oauth2Token) valid() bool {
+	return t.expiresAt.IsZero() || time.Now().Add(oauth2ExpiryDelta).Before(t.expiresAt)
+}
+
+func (t oauth2Token) metadata() OAuth2Token {
+	m := OAuth2Token{TokenType: t.tokenType, Cached: t.cached}
+	if !t.expiresAt.IsZero() {
+		m.ExpiresAt = t.expiresAt.Format(time.RFC3339)
+	}
+	return m
+}
+
+// The action plugin lives through a workflow run, so tokens are reused by the steps and the repeats.
+var (
+	oauth2Mu     sync.Mutex
+	oauth2Tokens = map[string]oauth2Token{}
+)
+
+// token returns the cached token, or fetches a new one when it is missing or expires soon.
+func (o OAuth2Options) token(tr hp.RoundTripper) (oauth2Token, error) {
+	key := o.ClientID + " " + o.TokenURL
+
+	oauth2Mu.Lock()
+	defer oauth2Mu.Unlock()
+
+	if t, ok := oauth2Tokens[key]; ok && t.valid() {
+		t.cached = true
+		return t, nil
+	}
+
+	t, err := o.fetch(tr)
+	if err != nil {
+		return t, err
+	}
+	oauth2Tokens[key] = t
+
+	return t, nil
+}
+
+func (o OAuth2Options) fetch(tr hp.RoundTripper) (oauth2Token, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if o.Scope != "" {
+		form.Set("scope", o.Scope)
+	}
+
+	req, err := hp.NewRequest(hp.MethodPost, o.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return oauth2Token{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(o.ClientID), url.QueryEscape(o.ClientSecret))
+
+	cl := &hp.Client{Transport: tr}
+	res, err := cl.Do(req)
+	if err != nil {
+		return oauth2Token{}, fmt.Errorf("oauth2 token request failed: %w", err)
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return oauth2Token{}, err
+	}
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return oauth2Token{}, fmt.Errorf("oauth2 token request failed: %s: %s", res.Status, body)
+	}
+
+	var data struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return oauth2Token{}, fmt.Errorf("oauth2 token response is invalid: %w", err)
+	}
+	if data.AccessToken == "" {
+		return oauth2Token{}, fmt.Errorf("oauth2 token response has no access_token")
+	}
+
+	t := oauth2Token{accessToken: data.AccessToken, tokenType: data.TokenType}
+	if data.ExpiresIn > 0 {
+		t.expiresAt = time.Now().Add(time.Duration(data.ExpiresIn) * time.Second)
+	}
+
+	return t, nil
+}
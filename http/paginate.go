@@ -0,0 +1,129 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	hp "net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const defaultMaxPages = 10
+
+// linkNextRegexp finds the url of rel=next in the Link header
+var linkNextRegexp = regexp.MustCompile(`<([^>]+)>\s*;[^,]*\brel="?next"?`)
+
+// PaginateOptions follow the next pages of the response, and collect the items of all pages into res.items.
+// Items and next are dot separated paths in the json body like `data` or `links.next`, and `.` is the body itself.
+// When next is empty, the url of rel=next in the Link header is followed.
+// The status, headers and body in the response are of the first page.
+type PaginateOptions struct {
+	Items    string `map:"items"`
+	Next     string `map:"next"`
+	MaxPages int    `map:"max_pages"`
+}
+
+// follow fetches the next pages with the method, headers and body of the first request, up to max_pages pages.
+func (p PaginateOptions) follow(cl *hp.Client, first *hp.Request, body, resBody []byte, header hp.Header, res *Res) error {
+	maxPages := p.MaxPages
+	if maxPages <= 0 {
+		maxPages = defaultMaxPages
+	}
+
+	u := first.URL
+	visited := map[string]bool{u.String(): true}
+	for page := 1; ; page++ {
+		var data any
+		if err := json.Unmarshal(resBody, &data); err != nil {
+			return fmt.Errorf("paginate: page %d is not json: %w", page, err)
+		}
+		items, ok := lookupPath(data, p.Items).([]any)
+		if !ok {
+			return fmt.Errorf("paginate: items '%s' is not an array in page %d", p.Items, page)
+		}
+		res.Items = append(res.Items, items...)
+		res.Pages = page
+
+		next := p.next(data, header)
+		if next == "" || page == maxPages {
+			return nil
+		}
+		nextURL, err := u.Parse(next)
+		if err != nil {
+			return fmt.Errorf("paginate: invalid next url in page %d: %w", page, err)
+		}
+		if visited[nextURL.String()] {
+			return nil
+		}
+		visited[nextURL.String()] = true
+		u = nextURL
+
+		req, err := hp.NewRequest(first.Method, u.String(), bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header = first.Header.Clone()
+
+		r, err := cl.Do(req)
+		if err != nil {
+			return err
+		}
+		if r.StatusCode < 200 || r.StatusCode >= 300 {
+			r.Body.Close()
+			return fmt.Errorf("paginate: page %d responded with %s", page+1, r.Status)
+		}
+		resBody, err = io.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			return err
+		}
+		if resBody, err = decodeBody(r.Header.Get("Content-Encoding"), resBody); err != nil {
+			return err
+		}
+		header = r.Header
+	}
+}
+
+// next returns the url of the next page, or an empty string on the last page.
+func (p PaginateOptions) next(data any, header hp.Header) string {
+	if p.Next != "" {
+		if s, ok := lookupPath(data, p.Next).(string); ok {
+			return s
+		}
+		return ""
+	}
+
+	for _, link := range header.Values("Link") {
+		if m := linkNextRegexp.FindStringSubmatch(link); m != nil {
+			return m[1]
+		}
+	}
+	return ""
+}
+
+// lookupPath returns the value at the dot separated path like `data.users.0`, or nil when it is missing.
+func lookupPath(data any, path string) any {
+	if path == "" || path == "." {
+		return data
+	}
+
+	cur := data
+	for _, key := range strings.Split(path, ".") {
+		switch v := cur.(type) {
+		case map[string]any:
+			cur = v[key]
+		case []any:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil
+			}
+			cur = v[i]
+		default:
+			return nil
+		}
+	}
+	return cur
+}
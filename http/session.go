@@ -0,0 +1,26 @@
+package http
+
+import (
+	"net/http/cookiejar"
+	"sync"
+)
+
+// Cookie jars by the session param. The action plugin lives through a workflow run, so steps with
+// the same session send the cookies set by the responses of the previous steps, like a browser.
+var (
+	sessionsMu sync.Mutex
+	sessions   = map[string]*cookiejar.Jar{}
+)
+
+func sessionJar(name string) *cookiejar.Jar {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+
+	jar, ok := sessions[name]
+	if !ok {
+		// the error is always nil without options
+		jar, _ = cookiejar.New(nil)
+		sessions[name] = jar
+	}
+	return jar
+}
@@ -0,0 +1,87 @@
+package http
+
+import (
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// Timing is the time taken by each phase of the request, like curl's -w. DNS, connect and tls are zero
+// when a kept alive connection is reused, and ttfb and total are from the start of the request.
+type Timing struct {
+	DNS     time.Duration `map:"dns"`
+	Connect time.Duration `map:"connect"`
+	TLS     time.Duration `map:"tls"`
+	TTFB    time.Duration `map:"ttfb"`
+	Total   time.Duration `map:"total"`
+}
+
+// tracer records the timing and the local address of a request. Callbacks of the trace can run
+// on other goroutines, like parallel dials of happy eyeballs, so that fields are guarded by the mutex.
+type tracer struct {
+	mu           sync.Mutex
+	start        time.Time
+	dnsStart     time.Time
+	connectStart time.Time
+	tlsStart     time.Time
+	timing       Timing
+	localAddr    string
+}
+
+func newTracer() *tracer {
+	return &tracer{start: time.Now()}
+}
+
+// lock runs f with the mutex held.
+func (t *tracer) lock(f func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	f()
+}
+
+func (t *tracer) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			t.lock(func() { t.dnsStart = time.Now() })
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			t.lock(func() { t.timing.DNS = time.Since(t.dnsStart) })
+		},
+		// with parallel dials, connect is from the first dial to the first established connection
+		ConnectStart: func(_, _ string) {
+			t.lock(func() {
+				if t.connectStart.IsZero() {
+					t.connectStart = time.Now()
+				}
+			})
+		},
+		ConnectDone: func(_, _ string, err error) {
+			t.lock(func() {
+				if err == nil && t.timing.Connect == 0 {
+					t.timing.Connect = time.Since(t.connectStart)
+				}
+			})
+		},
+		TLSHandshakeStart: func() {
+			t.lock(func() { t.tlsStart = time.Now() })
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			t.lock(func() { t.timing.TLS = time.Since(t.tlsStart) })
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.lock(func() { t.localAddr = info.Conn.LocalAddr().String() })
+		},
+		GotFirstResponseByte: func() {
+			t.lock(func() { t.timing.TTFB = time.Since(t.start) })
+		},
+	}
+}
+
+// done records the total time, and returns the timing and the local address.
+func (t *tracer) done() (Timing, string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.timing.Total = time.Since(t.start)
+	return t.timing, t.localAddr
+}
@@ -0,0 +1,114 @@
+package probe
+
+import (
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	redacted = "[REDACTED]"
+	// shorter values are not masked, because they would hide unrelated output
+	minSecretLength = 4
+)
+
+// defaultSecretPatterns are key patterns of which values are masked in the output.
+var defaultSecretPatterns = []string{"*password*", "*passwd*", "*secret*", "*token*", "*api_key*", "*apikey*"}
+
+// isSecretKey reports whether the key matches any of the patterns, case-insensitively.
+func isSecretKey(key string, patterns []string) bool {
+	key = strings.ToLower(key)
+	for _, p := range patterns {
+		if ok, _ := path.Match(strings.ToLower(p), key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// secretValues collects values of secret keys in vars, env and params of steps.
+// Params including expressions are skipped, since their sources are masked instead.
+func (w *Workflow) secretValues(vars map[string]any) []string {
+	patterns := append(append([]string{}, defaultSecretPatterns...), w.Secrets...)
+	found := map[string]bool{}
+
+	var collect func(v any, secret bool)
+	collect = func(v any, secret bool) {
+		switch val := v.(type) {
+		case map[string]any:
+			for k, vv := range val {
+				collect(vv, secret || isSecretKey(k, patterns))
+			}
+		case []any:
+			for _, vv := range val {
+				collect(vv, secret)
+			}
+		case string:
+			if secret && len(val) >= minSecretLength && !templateRegexp.MatchString(val) {
+				found[val] = true
+			}
+		}
+	}
+
+	collect(vars, false)
+	collect(StrmapToAnymap(w.Env()), false)
+	for _, job := range w.Jobs {
+		for _, st := range job.Steps {
+			collect(st.With, false)
+		}
+	}
+	for _, steps := range w.hooks() {
+		for _, st := range steps {
+			collect(st.With, false)
+		}
+	}
+
+	values := make([]string, 0, len(found))
+	for v := range found {
+		values = append(values, v)
+	}
+	// longer values first, so that a secret containing another one is masked as a whole
+	sort.Slice(values, func(i, j int) bool {
+		if len(values[i]) != len(values[j]) {
+			return len(values[i]) > len(values[j])
+		}
+		return values[i] < values[j]
+	})
+
+	return values
+}
+
+type maskWriter struct {
+	mu       sync.Mutex
+	w        io.Writer
+	replacer *strings.Replacer
+}
+
+// newMaskWriter returns a writer that replaces the secrets with [REDACTED].
+// Writes are serialized, since jobs running at the same time write to it.
+func newMaskWriter(w io.Writer, secrets []string) io.Writer {
+	m := &maskWriter{w: w}
+	if len(secrets) == 0 {
+		return m
+	}
+	pairs := make([]string, 0, len(secrets)*2)
+	for _, s := range secrets {
+		pairs = append(pairs, s, redacted)
+	}
+	m.replacer = strings.NewReplacer(pairs...)
+	return m
+}
+
+func (m *maskWriter) Write(p []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.replacer == nil {
+		return m.w.Write(p)
+	}
+	if _, err := io.WriteString(m.w, m.replacer.Replace(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,94 @@
+package probe
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestIsSecretKey(t *testing.T) {
+	tests := []struct {
+		key      string
+		expected bool
+	}{
+		{"password", true},
+		{"DB_PASSWORD", true},
+		{"AccessToken", true},
+		{"x-api-key", false},
+		{"username", false},
+	}
+	for _, tt := range tests {
+		if got := isSecretKey(tt.key, defaultSecretPatterns); got != tt.expected {
+			t.Errorf("%s: expected %t, got %t", tt.key, tt.expected, got)
+		}
+	}
+}
+
+func TestSecretValues(t *testing.T) {
+	wf := &Workflow{
+		Secrets: []string{"x-api-key"},
+		Jobs: []Job{{Steps: []*Step{{With: map[string]any{
+			"headers":  map[string]any{"x-api-key": "key-123456", "accept": "text/plain"},
+			"password": "{vars.db_password}",
+		}}}}},
+		env: map[string]string{"GITHUB_TOKEN": "ghp_abcdef", "HOME": "/root"},
+	}
+	vars := map[string]any{"db_password": "hunter2!", "user": "alice", "pin": "123"}
+
+	expected := []string{"ghp_abcdef", "key-123456", "hunter2!"}
+	if got := wf.secretValues(vars); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %#v, got %#v", expected, got)
+	}
+}
+
+func TestMaskOutput(t *testing.T) {
+	stubRunActions(t, func(ctx context.Context, name string, args []string, with map[string]any, verbose bool) (map[string]any, error) {
+		return map[string]any{"req": with, "res": map[string]any{"code": 401, "body": "invalid token " + with["token"].(string)}}, nil
+	})
+
+	for _, verbose := range []bool{false, true} {
+		var buf bytes.Buffer
+		steps := []*Step{{
+			Uses: "http",
+			With: map[string]any{"token": "{vars.api_token}"},
+			Test: "res.code == 200",
+			Echo: "vars.api_token",
+		}}
+		wf := &Workflow{
+			Name: "Test",
+			Jobs: []Job{{Name: "Job", Steps: steps}},
+			Vars: map[string]any{"api_token": "s3cr3t-value"},
+			env:  map[string]string{},
+		}
+		if err := wf.Start(Config{Log: &buf, Verbose: verbose, OutputFormat: ""}); err != nil {
+			t.Fatalf("unexpected error %s", err)
+		}
+
+		out := buf.String()
+		if strings.Contains(out, "s3cr3t-value") {
+			t.Errorf("verbose %t: secret appeared in output:\n%s", verbose, out)
+		}
+		if !strings.Contains(out, redacted) {
+			t.Errorf("verbose %t: expected %s in output:\n%s", verbose, redacted, out)
+		}
+	}
+}
+
+func TestMaskReport(t *testing.T) {
+	stubRunActions(t, func(ctx context.Context, name string, args []string, with map[string]any, verbose bool) (map[string]any, error) {
+		return map[string]any{"req": with, "res": map[string]any{"body": with["password"]}}, nil
+	})
+
+	var buf bytes.Buffer
+	steps := []*Step{{Uses: "http", With: map[string]any{"password": "p@ssw0rd"}, Test: "res.body == ''"}}
+	wf := &Workflow{Name: "Test", Jobs: []Job{{Name: "Job", Steps: steps}}, env: map[string]string{}}
+	if err := wf.Start(Config{Log: &buf, OutputFormat: OutputFormatJSON}); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	if strings.Contains(buf.String(), "p@ssw0rd") {
+		t.Errorf("secret appeared in report:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,159 @@
+package probe
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	hp "net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+	metricsPushTimeout = 10 * time.Second
+)
+
+// MetricsBuckets are upper bounds in seconds of the step duration histogram.
+var MetricsBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Metrics aggregates results of steps across runs, and exposes them in the prometheus text format.
+// It serves `/metrics` as a http.Handler, or pushes them to a pushgateway.
+type Metrics struct {
+	mu    sync.Mutex
+	steps map[metricsKey]*stepMetrics
+}
+
+type metricsKey struct {
+	job  string
+	step string
+}
+
+type stepMetrics struct {
+	status  map[string]int
+	buckets []int
+	sum     float64
+	count   int
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{steps: map[metricsKey]*stepMetrics{}}
+}
+
+// Observe counts the step result by the status, and records the duration.
+func (m *Metrics) Observe(job string, sr StepResult) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := metricsKey{job: job, step: sr.Name}
+	s, ok := m.steps[key]
+	if !ok {
+		s = &stepMetrics{status: map[string]int{}, buckets: make([]int, len(MetricsBuckets))}
+		m.steps[key] = s
+	}
+	s.status[sr.Status]++
+	sec := sr.Duration.Seconds()
+	for i, le := range MetricsBuckets {
+		if sec <= le {
+			s.buckets[i]++
+		}
+	}
+	s.sum += sec
+	s.count++
+}
+
+// Write renders the metrics in the prometheus text format.
+func (m *Metrics) Write(w io.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := make([]metricsKey, 0, len(m.steps))
+	for k := range m.steps {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].job != keys[j].job {
+			return keys[i].job < keys[j].job
+		}
+		return keys[i].step < keys[j].step
+	})
+
+	var b bytes.Buffer
+	b.WriteString("# HELP probe_step_total Number of step runs by status.\n")
+	b.WriteString("# TYPE probe_step_total counter\n")
+	for _, k := range keys {
+		s := m.steps[k]
+		statuses := make([]string, 0, len(s.status))
+		for st := range s.status {
+			statuses = append(statuses, st)
+		}
+		sort.Strings(statuses)
+		for _, st := range statuses {
+			fmt.Fprintf(&b, "probe_step_total{%s,status=%s} %d\n", k.labels(), quoteLabel(st), s.status[st])
+		}
+	}
+
+	b.WriteString("# HELP probe_step_duration_seconds Duration of steps in seconds.\n")
+	b.WriteString("# TYPE probe_step_duration_seconds histogram\n")
+	for _, k := range keys {
+		s := m.steps[k]
+		for i, le := range MetricsBuckets {
+			fmt.Fprintf(&b, "probe_step_duration_seconds_bucket{%s,le=%s} %d\n",
+				k.labels(), quoteLabel(strconv.FormatFloat(le, 'g', -1, 64)), s.buckets[i])
+		}
+		fmt.Fprintf(&b, "probe_step_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", k.labels(), s.count)
+		fmt.Fprintf(&b, "probe_step_duration_seconds_sum{%s} %s\n", k.labels(), strconv.FormatFloat(s.sum, 'g', -1, 64))
+		fmt.Fprintf(&b, "probe_step_duration_seconds_count{%s} %d\n", k.labels(), s.count)
+	}
+
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+func (m *Metrics) ServeHTTP(w hp.ResponseWriter, r *hp.Request) {
+	w.Header().Set("Content-Type", metricsContentType)
+	_ = m.Write(w)
+}
+
+// Push replaces the metrics of the probe job in the pushgateway at the url.
+func (m *Metrics) Push(url string) error {
+	var b bytes.Buffer
+	if err := m.Write(&b); err != nil {
+		return err
+	}
+
+	req, err := hp.NewRequest(hp.MethodPut, strings.TrimSuffix(url, "/")+"/metrics/job/probe", &b)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", metricsContentType)
+
+	cl := &hp.Client{Timeout: metricsPushTimeout}
+	res, err := cl.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, res.Body)
+
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("pushgateway responded with %s", res.Status)
+	}
+	return nil
+}
+
+// labels returns the labels of the key. The job of the workflow is labeled probe_job,
+// since job is the grouping label of the pushgateway.
+func (k metricsKey) labels() string {
+	return fmt.Sprintf("probe_job=%s,step=%s", quoteLabel(k.job), quoteLabel(k.step))
+}
+
+// labelReplacer escapes label values of the text format
+var labelReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func quoteLabel(v string) string {
+	return `"` + labelReplacer.Replace(v) + `"`
+}
@@ -0,0 +1,98 @@
+package probe
+
+import (
+	"bytes"
+	"context"
+	"io"
+	hp "net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsWrite(t *testing.T) {
+	m := NewMetrics()
+	m.Observe("API", StepResult{Name: `Get "users"`, Status: StatusSuccess, Duration: 30 * time.Millisecond})
+	m.Observe("API", StepResult{Name: `Get "users"`, Status: StatusFailure, Duration: 2 * time.Second})
+	m.Observe("API", StepResult{Name: `Get "users"`, Status: StatusSuccess, Duration: 20 * time.Millisecond})
+
+	var buf bytes.Buffer
+	if err := m.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	got := buf.String()
+	for _, expected := range []string{
+		"# TYPE probe_step_total counter\n",
+		`probe_step_total{probe_job="API",step="Get \"users\"",status="failure"} 1` + "\n",
+		`probe_step_total{probe_job="API",step="Get \"users\"",status="success"} 2` + "\n",
+		"# TYPE probe_step_duration_seconds histogram\n",
+		`probe_step_duration_seconds_bucket{probe_job="API",step="Get \"users\"",le="0.025"} 1` + "\n",
+		`probe_step_duration_seconds_bucket{probe_job="API",step="Get \"users\"",le="0.05"} 2` + "\n",
+		`probe_step_duration_seconds_bucket{probe_job="API",step="Get \"users\"",le="2.5"} 3` + "\n",
+		`probe_step_duration_seconds_bucket{probe_job="API",step="Get \"users\"",le="+Inf"} 3` + "\n",
+		`probe_step_duration_seconds_sum{probe_job="API",step="Get \"users\""} 2.05` + "\n",
+		`probe_step_duration_seconds_count{probe_job="API",step="Get \"users\""} 3` + "\n",
+	} {
+		if !strings.Contains(got, expected) {
+			t.Errorf("expected %q in:\n%s", expected, got)
+		}
+	}
+}
+
+func TestMetricsAcrossRuns(t *testing.T) {
+	stubRunActions(t, func(ctx context.Context, name string, args []string, with map[string]any, verbose bool) (map[string]any, error) {
+		return map[string]any{"req": map[string]any{}, "res": map[string]any{"code": 200}}, nil
+	})
+
+	m := NewMetrics()
+	for i := 0; i < 2; i++ {
+		wf := &Workflow{
+			Name: "Health",
+			Jobs: []Job{{Name: "API", Steps: []*Step{{Name: "Get users", Uses: "http", Test: "res.code == 200"}}}},
+			env:  map[string]string{},
+		}
+		if err := wf.Start(Config{Log: &bytes.Buffer{}, Metrics: m}); err != nil {
+			t.Fatalf("unexpected error %s", err)
+		}
+	}
+
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+	res, err := hp.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	b, _ := io.ReadAll(res.Body)
+
+	expected := `probe_step_total{probe_job="API",step="Get users",status="success"} 2`
+	if !strings.Contains(string(b), expected) {
+		t.Errorf("expected %q in:\n%s", expected, b)
+	}
+	if ct := res.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("unexpected content type %s", ct)
+	}
+}
+
+func TestMetricsPush(t *testing.T) {
+	var method, path string
+	var body []byte
+	ts := httptest.NewServer(hp.HandlerFunc(func(w hp.ResponseWriter, r *hp.Request) {
+		method, path = r.Method, r.URL.Path
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer ts.Close()
+
+	m := NewMetrics()
+	m.Observe("API", StepResult{Name: "Get users", Status: StatusSuccess, Duration: time.Millisecond})
+	if err := m.Push(ts.URL + "/"); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if method != hp.MethodPut || path != "/metrics/job/probe" {
+		t.Errorf("unexpected request %s %s", method, path)
+	}
+	if !strings.Contains(string(body), "probe_step_total") {
+		t.Errorf("unexpected body %s", body)
+	}
+}
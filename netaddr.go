@@ -0,0 +1,49 @@
+package probe
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// LocalAddr resolves the local address to bind like `192.0.2.1` or `192.0.2.1:10000` for the network,
+// which is tcp or udp. It returns an error when the ip is not assigned to any interface of the host.
+func LocalAddr(network, addr string) (net.Addr, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		host, port = strings.Trim(addr, "[]"), "0"
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, fmt.Errorf("local_addr must be an ip address: %s", addr)
+	}
+
+	if !ip.IsUnspecified() {
+		assigned, err := isAssigned(ip)
+		if err != nil {
+			return nil, err
+		}
+		if !assigned {
+			return nil, fmt.Errorf("local_addr %s is not assigned to any interface", ip)
+		}
+	}
+
+	hostport := net.JoinHostPort(ip.String(), port)
+	if strings.HasPrefix(network, "udp") {
+		return net.ResolveUDPAddr(network, hostport)
+	}
+	return net.ResolveTCPAddr(network, hostport)
+}
+
+func isAssigned(ip net.IP) (bool, error) {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false, err
+	}
+	for _, a := range addrs {
+		if ipnet, ok := a.(*net.IPNet); ok && ipnet.IP.Equal(ip) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
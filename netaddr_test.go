@@ -0,0 +1,36 @@
+package probe
+
+import "testing"
+
+func TestLocalAddr(t *testing.T) {
+	tests := []struct {
+		network  string
+		addr     string
+		expected string
+		err      string
+	}{
+		{network: "tcp", addr: "127.0.0.1", expected: "127.0.0.1:0"},
+		{network: "udp", addr: "127.0.0.1:10053", expected: "127.0.0.1:10053"},
+		{network: "tcp", addr: "0.0.0.0", expected: "0.0.0.0:0"},
+		{network: "tcp", addr: "localhost", err: "local_addr must be an ip address: localhost"},
+		{network: "tcp", addr: "192.0.2.1", err: "local_addr 192.0.2.1 is not assigned to any interface"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			got, err := LocalAddr(tt.network, tt.addr)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Errorf("expected error %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got error %s", err)
+			}
+			if got.Network() != tt.network || got.String() != tt.expected {
+				t.Errorf("expected %s %s, got %s %s", tt.network, tt.expected, got.Network(), got)
+			}
+		})
+	}
+}
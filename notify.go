@@ -0,0 +1,164 @@
+package probe
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	hp "net/http"
+	"strings"
+	"time"
+)
+
+const (
+	NotifyFormatSlack = "slack"
+	NotifyFormatJSON  = "json"
+
+	notifyTimeout = 10 * time.Second
+	// failures beyond this are summarized, because slack limits the size of a block
+	notifyMaxFailures = 10
+)
+
+// Notify posts a summary of the result to the webhook url when the workflow finishes.
+// The format is slack by default. With the json format, the template is sent as the body
+// after expressions like `{failed}` in it are evaluated with NotifyContext.
+// The webhook url can be given by an expression like `{env.SLACK_WEBHOOK_URL}`.
+type Notify struct {
+	WebhookURL string `yaml:"webhook_url" validate:"required"`
+	Format     string `yaml:"format,omitempty" validate:"omitempty,oneof=slack json"`
+	Template   string `yaml:"template,omitempty"`
+}
+
+// NotifyContext is the environment of expressions in the notify template.
+type NotifyContext struct {
+	Name     string           `expr:"name"`
+	Total    int              `expr:"total"`
+	Passed   int              `expr:"passed"`
+	Failed   int              `expr:"failed"`
+	Skipped  int              `expr:"skipped"`
+	Duration string           `expr:"duration"`
+	Failures []map[string]any `expr:"failures"`
+	Vars     map[string]any   `expr:"vars"`
+}
+
+func newNotifyContext(r *Result, vars map[string]any) NotifyContext {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ctx := NotifyContext{
+		Name:     r.Name,
+		Total:    len(r.Jobs),
+		Duration: r.Duration().Round(time.Millisecond).String(),
+		Failures: []map[string]any{},
+		Vars:     vars,
+	}
+	for _, j := range r.Jobs {
+		switch {
+		// jobs aborted by fail-fast did not complete, like skipped ones
+		case j.Skipped, j.Aborted && !j.Failed:
+			ctx.Skipped++
+		case j.Failed:
+			ctx.Failed++
+		default:
+			ctx.Passed++
+		}
+		for _, s := range j.Steps {
+			if s.Status != StatusFailure && s.Status != StatusError && s.Status != StatusSlow {
+				continue
+			}
+			ctx.Failures = append(ctx.Failures, map[string]any{
+				"job":     j.Name,
+				"step":    s.Name,
+				"status":  s.Status,
+				"message": s.Message,
+			})
+		}
+	}
+
+	return ctx
+}
+
+// payload returns the body to post, of which secrets are masked.
+func (n *Notify) payload(ctx NotifyContext, secrets []string) ([]byte, error) {
+	var body []byte
+	switch n.Format {
+	case "", NotifyFormatSlack:
+		b, err := json.Marshal(slackMessage(ctx))
+		if err != nil {
+			return nil, err
+		}
+		body = b
+	case NotifyFormatJSON:
+		expr := &Expr{}
+		s, err := expr.EvalTemplate(n.Template, ctx)
+		if err != nil {
+			return nil, err
+		}
+		if !json.Valid([]byte(s)) {
+			return nil, fmt.Errorf("notify template is not valid json: %s", s)
+		}
+		body = []byte(s)
+	default:
+		return nil, fmt.Errorf("unknown notify format '%s'", n.Format)
+	}
+
+	var buf bytes.Buffer
+	if _, err := newMaskWriter(&buf, secrets).Write(body); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func slackMessage(ctx NotifyContext) map[string]any {
+	status, emoji := "passed", ":white_check_mark:"
+	if ctx.Failed > 0 {
+		status, emoji = "failed", ":x:"
+	}
+	summary := fmt.Sprintf("%s *%s* %s\n%d passed, %d failed, %d skipped of %d jobs in %s",
+		emoji, ctx.Name, status, ctx.Passed, ctx.Failed, ctx.Skipped, ctx.Total, ctx.Duration)
+
+	blocks := []map[string]any{slackSection(summary)}
+	if len(ctx.Failures) > 0 {
+		var lines []string
+		for i, f := range ctx.Failures {
+			if i == notifyMaxFailures {
+				lines = append(lines, fmt.Sprintf("and %d more", len(ctx.Failures)-i))
+				break
+			}
+			line := fmt.Sprintf("• %s / %s: %s", f["job"], f["step"], f["status"])
+			if msg, _ := f["message"].(string); msg != "" {
+				line += " - " + msg
+			}
+			lines = append(lines, line)
+		}
+		blocks = append(blocks, slackSection(strings.Join(lines, "\n")))
+	}
+
+	return map[string]any{
+		"text":   fmt.Sprintf("%s %s", ctx.Name, status),
+		"blocks": blocks,
+	}
+}
+
+func slackSection(text string) map[string]any {
+	return map[string]any{
+		"type": "section",
+		"text": map[string]any{"type": "mrkdwn", "text": text},
+	}
+}
+
+// Send posts the payload to the url.
+func (n *Notify) Send(url string, body []byte) error {
+	cl := &hp.Client{Timeout: notifyTimeout}
+	res, err := cl.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, res.Body)
+
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with %s", res.Status)
+	}
+	return nil
+}
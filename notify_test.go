@@ -0,0 +1,112 @@
+package probe
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	hp "net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func startNotifyServer(t *testing.T, code int) (*httptest.Server, *[]byte) {
+	t.Helper()
+	var body []byte
+	ts := httptest.NewServer(hp.HandlerFunc(func(w hp.ResponseWriter, r *hp.Request) {
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(code)
+	}))
+	t.Cleanup(ts.Close)
+	return ts, &body
+}
+
+func notifyWorkflow(notify *Notify) *Workflow {
+	return &Workflow{
+		Name: "Health",
+		Jobs: []Job{
+			{Name: "API", Steps: []*Step{{Name: "Get users", Uses: "http", Test: "res.code == 200"}}},
+			{Name: "Web", Steps: []*Step{{Name: "Get top", Uses: "http"}}},
+		},
+		Vars:   map[string]any{"api_token": "s3cr3t-value"},
+		Notify: notify,
+		env:    map[string]string{"WEBHOOK_PATH": "/hooks/probe"},
+	}
+}
+
+func TestNotifySlack(t *testing.T) {
+	stubRunActions(t, func(ctx context.Context, name string, args []string, with map[string]any, verbose bool) (map[string]any, error) {
+		return map[string]any{"req": map[string]any{}, "res": map[string]any{"code": 500}}, nil
+	})
+	ts, body := startNotifyServer(t, hp.StatusOK)
+
+	wf := notifyWorkflow(&Notify{WebhookURL: ts.URL + "{env.WEBHOOK_PATH}"})
+	if err := wf.Start(Config{Log: &bytes.Buffer{}}); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	var msg struct {
+		Text   string `json:"text"`
+		Blocks []struct {
+			Text struct {
+				Text string `json:"text"`
+			} `json:"text"`
+		} `json:"blocks"`
+	}
+	if err := json.Unmarshal(*body, &msg); err != nil {
+		t.Fatalf("invalid payload %s: %s", *body, err)
+	}
+	if msg.Text != "Health failed" {
+		t.Errorf("unexpected text %q", msg.Text)
+	}
+	if len(msg.Blocks) != 2 {
+		t.Fatalf("expected 2 blocks, got %s", *body)
+	}
+	if !strings.Contains(msg.Blocks[0].Text.Text, "1 passed, 1 failed, 0 skipped of 2 jobs") {
+		t.Errorf("unexpected summary %q", msg.Blocks[0].Text.Text)
+	}
+	if !strings.Contains(msg.Blocks[1].Text.Text, "API / Get users: failure") {
+		t.Errorf("unexpected failures %q", msg.Blocks[1].Text.Text)
+	}
+}
+
+func TestNotifyJSONTemplate(t *testing.T) {
+	stubRunActions(t, func(ctx context.Context, name string, args []string, with map[string]any, verbose bool) (map[string]any, error) {
+		return map[string]any{"req": map[string]any{}, "res": map[string]any{"code": 200}}, nil
+	})
+	ts, body := startNotifyServer(t, hp.StatusOK)
+
+	wf := notifyWorkflow(&Notify{
+		WebhookURL: ts.URL,
+		Format:     NotifyFormatJSON,
+		Template:   `{"workflow": "{name}", "failed": {failed}, "total": {total}, "token": "{vars.api_token}"}`,
+	})
+	if err := wf.Start(Config{Log: &bytes.Buffer{}}); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	expected := `{"workflow": "Health", "failed": 0, "total": 2, "token": "[REDACTED]"}`
+	if string(*body) != expected {
+		t.Errorf("expected %s, got %s", expected, *body)
+	}
+}
+
+func TestNotifyDeliveryFailure(t *testing.T) {
+	stubRunActions(t, func(ctx context.Context, name string, args []string, with map[string]any, verbose bool) (map[string]any, error) {
+		return map[string]any{"req": map[string]any{}, "res": map[string]any{"code": 200}}, nil
+	})
+	ts, _ := startNotifyServer(t, hp.StatusInternalServerError)
+
+	var buf bytes.Buffer
+	wf := notifyWorkflow(&Notify{WebhookURL: ts.URL})
+	if err := wf.Start(Config{Log: &buf}); err != nil {
+		t.Fatalf("expected delivery failures not to fail the workflow, got %s", err)
+	}
+	if wf.exitStatus != ExitSuccess {
+		t.Errorf("unexpected exit status %d", wf.exitStatus)
+	}
+	if !strings.Contains(buf.String(), "Notify Error: webhook responded with 500 Internal Server Error") {
+		t.Errorf("expected the delivery failure to be logged:\n%s", buf.String())
+	}
+}
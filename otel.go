@@ -0,0 +1,119 @@
+package probe
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+const (
+	defaultOtelServiceName = "probe"
+	otelShutdownTimeout    = 10 * time.Second
+)
+
+// Otel exports traces of the run to the otlp/http endpoint, like `http://localhost:4318`.
+// The workflow is the root span, and jobs and steps are child spans.
+// The trace context is propagated to requests of the http action by the traceparent header.
+type Otel struct {
+	Endpoint    string            `yaml:"endpoint" validate:"required"`
+	ServiceName string            `yaml:"service_name,omitempty"`
+	Headers     map[string]string `yaml:"headers,omitempty"`
+}
+
+// newTracer returns the tracer exporting to the endpoint, and the function to flush spans.
+// Without otel, the tracer records nothing.
+func (w *Workflow) newTracer(ctx JobContext) (trace.Tracer, func() error, error) {
+	if w.Otel == nil {
+		return noop.NewTracerProvider().Tracer(""), func() error { return nil }, nil
+	}
+
+	expr := &Expr{}
+	endpoint, err := expr.EvalTemplate(w.Otel.Endpoint, ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	headers := map[string]string{}
+	for k, v := range w.Otel.Headers {
+		if headers[k], err = expr.EvalTemplate(v, ctx); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	exporter, err := otlptracehttp.New(context.Background(),
+		otlptracehttp.WithEndpointURL(endpoint),
+		otlptracehttp.WithHeaders(headers),
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	name := w.Otel.ServiceName
+	if name == "" {
+		name = defaultOtelServiceName
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", name))),
+	)
+	shutdown := func() error {
+		c, cancel := context.WithTimeout(context.Background(), otelShutdownTimeout)
+		defer cancel()
+		return tp.Shutdown(c)
+	}
+
+	return tp.Tracer("github.com/linyows/probe"), shutdown, nil
+}
+
+// endSpan ends the span with the status of the workflow, the job or the step.
+func endSpan(span trace.Span, status, message string) {
+	span.SetAttributes(attribute.String("probe.status", status))
+	switch status {
+	case StatusFailure, StatusError, StatusSlow:
+		span.SetStatus(codes.Error, message)
+	default:
+		span.SetStatus(codes.Ok, "")
+	}
+	span.End()
+}
+
+// injectTraceContext adds the traceparent header of the span to params of the http action.
+// Headers given in the workflow are left as they are.
+func injectTraceContext(ctx context.Context, with map[string]any) {
+	carrier := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(ctx, carrier)
+	if len(carrier) == 0 {
+		return
+	}
+
+	headers, ok := with["headers"].(map[string]any)
+	if !ok {
+		if with["headers"] != nil {
+			return
+		}
+		headers = map[string]any{}
+		with["headers"] = headers
+	}
+	for k, v := range carrier {
+		if !hasKeyFold(headers, k) {
+			headers[k] = v
+		}
+	}
+}
+
+func hasKeyFold(m map[string]any, key string) bool {
+	for k := range m {
+		if strings.EqualFold(k, key) {
+			return true
+		}
+	}
+	return false
+}
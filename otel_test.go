@@ -0,0 +1,80 @@
+package probe
+
+import (
+	"bytes"
+	"context"
+	"io"
+	hp "net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestOtel(t *testing.T) {
+	var traceparent any
+	stubRunActions(t, func(ctx context.Context, name string, args []string, with map[string]any, verbose bool) (map[string]any, error) {
+		traceparent = with["headers"].(map[string]any)["traceparent"]
+		return map[string]any{"req": map[string]any{}, "res": map[string]any{"code": 200}}, nil
+	})
+
+	var spans []string
+	collector := httptest.NewServer(hp.HandlerFunc(func(w hp.ResponseWriter, r *hp.Request) {
+		b, _ := io.ReadAll(r.Body)
+		var req coltracepb.ExportTraceServiceRequest
+		if err := proto.Unmarshal(b, &req); err != nil {
+			t.Errorf("invalid export %s", err)
+			return
+		}
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				for _, s := range ss.Spans {
+					spans = append(spans, s.Name)
+				}
+			}
+		}
+		w.Header().Set("Content-Type", "application/x-protobuf")
+	}))
+	defer collector.Close()
+
+	wf := &Workflow{
+		Name: "Health",
+		Jobs: []Job{{Name: "API", Steps: []*Step{{Name: "Get users", Uses: "http", With: map[string]any{"url": "http://localhost"}}}}},
+		Otel: &Otel{Endpoint: collector.URL},
+		env:  map[string]string{},
+	}
+	if err := wf.Start(Config{Log: &bytes.Buffer{}}); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	if len(spans) != 3 {
+		t.Fatalf("expected spans of the workflow, the job and the step, got %v", spans)
+	}
+	for _, name := range []string{"Health", "API", "Get users"} {
+		found := false
+		for _, s := range spans {
+			found = found || s == name
+		}
+		if !found {
+			t.Errorf("expected the span %q in %v", name, spans)
+		}
+	}
+
+	s, _ := traceparent.(string)
+	if !regexp.MustCompile(`^00-[0-9a-f]{32}-[0-9a-f]{16}-01$`).MatchString(s) {
+		t.Errorf("expected the traceparent header, got %#v", traceparent)
+	}
+}
+
+func TestInjectTraceContextKeepsHeaders(t *testing.T) {
+	ctx, span := (&JobContext{}).startSpan("step")
+	defer span.End()
+
+	with := map[string]any{"headers": map[string]any{"Traceparent": "given"}}
+	injectTraceContext(ctx, with)
+	if len(with["headers"].(map[string]any)) != 1 {
+		t.Errorf("expected the given header to be kept, got %v", with["headers"])
+	}
+}
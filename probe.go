@@ -5,6 +5,8 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"time"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/goccy/go-yaml"
@@ -17,18 +19,182 @@ type Probe struct {
 }
 
 type Config struct {
-	Log     io.Writer
-	Verbose bool
+	Log          io.Writer
+	Verbose      bool
+	DryRun       bool
+	NoColor      bool
+	ForceColor   bool
+	Timeline     bool
+	Compact      bool
+	FailFast     bool
+	Profile      string
+	OutputFormat string
+	SummaryPath  string
+	VarsFiles    []string
+	EnvFiles     []string
+	ExportEnv    bool
+	DumpDir      string
+	// LogFormat is text for the console output, or json for json lines of events.
+	LogFormat string
+	// Metrics aggregates results of steps when given, across runs sharing it.
+	Metrics *Metrics
+	// RerunFailed is the json result of a previous run, and only jobs failed in it run.
+	RerunFailed string
+	// Timeout caps the total run time of jobs. 0 is unlimited.
+	Timeout time.Duration
+	// MaxConcurrency limits the number of jobs running at the same time. 0 is unlimited.
+	MaxConcurrency int
 }
 
-func New(path string, v bool) *Probe {
-	return &Probe{
+type Option func(*Config)
+
+func New(path string, v bool, opts ...Option) *Probe {
+	p := &Probe{
 		FilePath: path,
 		config: Config{
 			Log:     os.Stdout,
 			Verbose: v,
 		},
 	}
+
+	for _, opt := range opts {
+		opt(&p.config)
+	}
+
+	return p
+}
+
+// WithOutputFormat writes the result as json or junit instead of the console report.
+func WithOutputFormat(f string) Option {
+	return func(c *Config) {
+		c.OutputFormat = f
+	}
+}
+
+// WithLogFormat writes json lines of events instead of the console output when the format is json.
+func WithLogFormat(f string) Option {
+	return func(c *Config) {
+		c.LogFormat = f
+	}
+}
+
+// WithMetrics records results of steps to m.
+func WithMetrics(m *Metrics) Option {
+	return func(c *Config) {
+		c.Metrics = m
+	}
+}
+
+// WithSummaryPath writes the step timing summary to path after the run.
+func WithSummaryPath(path string) Option {
+	return func(c *Config) {
+		c.SummaryPath = path
+	}
+}
+
+// WithVarsFiles adds vars files that override the vars files in the workflow.
+func WithVarsFiles(paths []string) Option {
+	return func(c *Config) {
+		c.VarsFiles = append(c.VarsFiles, paths...)
+	}
+}
+
+// WithEnvFiles loads environment variables from dotenv files, which do not override the process env.
+func WithEnvFiles(paths []string) Option {
+	return func(c *Config) {
+		c.EnvFiles = append(c.EnvFiles, paths...)
+	}
+}
+
+// WithExportEnv sets variables loaded from env files to the process env, so that actions see them.
+func WithExportEnv(b bool) Option {
+	return func(c *Config) {
+		c.ExportEnv = b
+	}
+}
+
+// WithMaxConcurrency limits the number of jobs running at the same time.
+func WithMaxConcurrency(n int) Option {
+	return func(c *Config) {
+		c.MaxConcurrency = n
+	}
+}
+
+// WithTimeout aborts the running and remaining jobs when the run takes longer than d.
+func WithTimeout(d time.Duration) Option {
+	return func(c *Config) {
+		c.Timeout = d
+	}
+}
+
+// WithRerunFailed runs only jobs failed in the previous run of which the json result is at path.
+func WithRerunFailed(path string) Option {
+	return func(c *Config) {
+		c.RerunFailed = path
+	}
+}
+
+// WithDryRun validates the workflow and prints the execution plan without running any actions.
+func WithDryRun(b bool) Option {
+	return func(c *Config) {
+		c.DryRun = b
+	}
+}
+
+// WithLog writes the console output and the report to w instead of stdout.
+func WithLog(w io.Writer) Option {
+	return func(c *Config) {
+		c.Log = w
+	}
+}
+
+// WithForceColor colors the output even when the log is not a terminal.
+func WithForceColor(b bool) Option {
+	return func(c *Config) {
+		c.ForceColor = b
+	}
+}
+
+// WithNoColor disables colored output.
+func WithNoColor(b bool) Option {
+	return func(c *Config) {
+		c.NoColor = b
+	}
+}
+
+// WithTimeline prints the start offset and duration of each step after the run.
+func WithTimeline(b bool) Option {
+	return func(c *Config) {
+		c.Timeline = b
+	}
+}
+
+// WithCompact prints a line per step with the rt, and the footer summary of steps.
+func WithCompact(b bool) Option {
+	return func(c *Config) {
+		c.Compact = b
+	}
+}
+
+// WithFailFast aborts the running and remaining jobs as soon as a job fails.
+func WithFailFast(b bool) Option {
+	return func(c *Config) {
+		c.FailFast = b
+	}
+}
+
+// WithProfile selects the profile of which vars override vars in the workflow.
+func WithProfile(name string) Option {
+	return func(c *Config) {
+		c.Profile = name
+	}
+}
+
+// WithDumpDir writes the full request and response of each step to a file in dir.
+func WithDumpDir(dir string) Option {
+	return func(c *Config) {
+		c.DumpDir = dir
+	}
 }
 
 func (p *Probe) Do() error {
@@ -43,23 +209,46 @@ func (p *Probe) ExitStatus() int {
 	return p.workflow.exitStatus
 }
 
+// Files returns the workflow file, and the vars files and the env files it loads.
+func (p *Probe) Files() []string {
+	files := append([]string{p.FilePath}, p.workflow.VarsFiles...)
+	return append(files, p.config.EnvFiles...)
+}
+
 func (p *Probe) Load() error {
 	y, err := ioutil.ReadFile(p.FilePath)
 	if err != nil {
-		return err
+		return &ConfigError{Err: err}
 	}
 
 	v := validator.New()
 	dec := yaml.NewDecoder(bytes.NewReader(y), yaml.Validator(v))
 	if err = dec.Decode(&p.workflow); err != nil {
-		return err
+		return &ConfigError{Err: err}
+	}
+
+	if err = p.workflow.expandTemplates(); err != nil {
+		return &ConfigError{Err: err}
 	}
 
 	p.setDefaultsToSteps()
+	p.setVarsFiles()
 
 	return nil
 }
 
+// setVarsFiles resolves vars files in the workflow relative to the workflow file,
+// and appends vars files given by config.
+func (p *Probe) setVarsFiles() {
+	dir := filepath.Dir(p.FilePath)
+	for i, path := range p.workflow.VarsFiles {
+		if !filepath.IsAbs(path) {
+			p.workflow.VarsFiles[i] = filepath.Join(dir, path)
+		}
+	}
+	p.workflow.VarsFiles = append(p.workflow.VarsFiles, p.config.VarsFiles...)
+}
+
 func (p *Probe) setDefaultsToSteps() {
 	for _, job := range p.workflow.Jobs {
 		if job.Defaults == nil {
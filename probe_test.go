@@ -2,6 +2,7 @@ package probe
 
 import (
 	"os"
+	"reflect"
 	"testing"
 
 	"github.com/goccy/go-yaml"
@@ -28,3 +29,14 @@ func TestLoad(t *testing.T) {
 		t.Errorf("\nExpected:\n%s\nGot:\n%s", expects, got)
 	}
 }
+
+func TestFiles(t *testing.T) {
+	p := New("./testdata/workflow.yml", false, WithVarsFiles([]string{"./local.env"}))
+	p.workflow.VarsFiles = []string{"vars.yml"}
+	p.setVarsFiles()
+
+	expected := []string{"./testdata/workflow.yml", "testdata/vars.yml", "./local.env"}
+	if got := p.Files(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %#v, got %#v", expected, got)
+	}
+}
@@ -0,0 +1,154 @@
+package probe
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+const (
+	progressInterval = 100 * time.Millisecond
+	// running steps beyond this are summarized, to keep the line short
+	progressMaxSteps = 3
+	clearLine        = "\r\033[K"
+)
+
+var spinnerFrames = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+// progress shows the number of finished jobs and the running steps in a line updated in place.
+// It wraps the console output, so that the output is written above the line.
+type progress struct {
+	w       io.Writer
+	total   int
+	done    int
+	running map[string]int
+	frame   int
+	partial bool
+	stopped bool
+	mu      sync.Mutex
+	quit    chan struct{}
+	wg      sync.WaitGroup
+}
+
+func newProgress(w io.Writer, total int) *progress {
+	return &progress{w: w, total: total, running: map[string]int{}, quit: make(chan struct{})}
+}
+
+// start spins the line until stop.
+func (p *progress) start() {
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		t := time.NewTicker(progressInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-p.quit:
+				return
+			case <-t.C:
+				p.mu.Lock()
+				p.frame = (p.frame + 1) % len(spinnerFrames)
+				p.draw()
+				p.mu.Unlock()
+			}
+		}
+	}()
+}
+
+// stop clears the line, and the output is written as it is after that.
+func (p *progress) stop() {
+	close(p.quit)
+	p.wg.Wait()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.partial {
+		fmt.Fprint(p.w, clearLine)
+	}
+	p.stopped = true
+}
+
+func (p *progress) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stopped {
+		return p.w.Write(b)
+	}
+
+	if !p.partial {
+		fmt.Fprint(p.w, clearLine)
+	}
+	n, err := p.w.Write(b)
+	// the line is drawn only after the output line is complete
+	p.partial = len(b) > 0 && b[len(b)-1] != '\n'
+	p.draw()
+
+	return n, err
+}
+
+func (p *progress) emit(e Event) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := e.Job + " / " + e.Step
+	switch e.Event {
+	case EventJobEnd:
+		p.done++
+	case EventStepStart:
+		p.running[key]++
+	case EventStepEnd:
+		if p.running[key]--; p.running[key] <= 0 {
+			delete(p.running, key)
+		}
+	default:
+		return
+	}
+	p.draw()
+}
+
+func (p *progress) draw() {
+	if p.partial || p.stopped {
+		return
+	}
+
+	steps := make([]string, 0, len(p.running))
+	for k := range p.running {
+		steps = append(steps, k)
+	}
+	sort.Strings(steps)
+	if len(steps) > progressMaxSteps {
+		steps = append(steps[:progressMaxSteps], fmt.Sprintf("+%d", len(steps)-progressMaxSteps))
+	}
+
+	line := fmt.Sprintf("%s %d/%d jobs", spinnerFrames[p.frame], p.done, p.total)
+	if len(steps) > 0 {
+		line += " · " + strings.Join(steps, ", ")
+	}
+	fmt.Fprint(p.w, clearLine+color.HiBlackString(line))
+}
+
+// runCount returns the number of job runs, where repeats with until count up to max_count.
+func (w *Workflow) runCount() int {
+	n := 0
+	for _, job := range w.Jobs {
+		switch {
+		case job.Repeat == nil:
+			n++
+		case job.Repeat.Until != "":
+			n += job.Repeat.maxCount()
+		default:
+			n += job.Repeat.Count
+		}
+	}
+	for _, steps := range w.hooks() {
+		if len(steps) > 0 {
+			n++
+		}
+	}
+	return n
+}
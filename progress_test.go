@@ -0,0 +1,47 @@
+package probe
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestProgress(t *testing.T) {
+	var buf bytes.Buffer
+	p := newProgress(&buf, 2)
+
+	p.emit(Event{Event: EventStepStart, Job: "API", Step: "Get users"})
+	if !strings.HasSuffix(buf.String(), "0/2 jobs · API / Get users") {
+		t.Errorf("expected the running step, got %q", buf.String())
+	}
+
+	// output is written above the line, and the line is drawn again
+	buf.Reset()
+	p.Write([]byte("API\n"))
+	if !strings.HasPrefix(buf.String(), clearLine+"API\n"+clearLine) {
+		t.Errorf("expected the line cleared before the output, got %q", buf.String())
+	}
+
+	// the line is not drawn after a partial line
+	buf.Reset()
+	p.Write([]byte(" 0. "))
+	p.emit(Event{Event: EventStepEnd, Job: "API", Step: "Get users"})
+	if buf.String() != clearLine+" 0. " {
+		t.Errorf("expected the partial line only, got %q", buf.String())
+	}
+	p.Write([]byte("Get users\n"))
+
+	buf.Reset()
+	p.emit(Event{Event: EventJobEnd, Job: "API"})
+	if !strings.HasSuffix(buf.String(), "1/2 jobs") {
+		t.Errorf("expected the finished job, got %q", buf.String())
+	}
+
+	p.start()
+	p.stop()
+	buf.Reset()
+	p.Write([]byte("done\n"))
+	if buf.String() != "done\n" {
+		t.Errorf("expected the output as it is after stop, got %q", buf.String())
+	}
+}
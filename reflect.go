@@ -3,17 +3,23 @@ package probe
 import (
 	"encoding/json"
 	"fmt"
+	"math"
 	"os"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
+var durationType = reflect.TypeOf(time.Duration(0))
+
 const (
 	flatkey       = "__"
 	tagMap        = "map"
 	tagValidate   = "validate"
 	labelRequired = "required"
+	optOmitempty  = "omitempty"
 )
 
 // merge string maps
@@ -73,7 +79,7 @@ func MapToStructByTags(params map[string]any, dest any) error {
 		fieldType := typ.Field(i)
 
 		// get the map tag
-		mapTag := fieldType.Tag.Get(tagMap)
+		mapTag, _ := parseMapTag(fieldType.Tag.Get(tagMap))
 		if mapTag == "" {
 			continue
 		}
@@ -119,7 +125,9 @@ func MapToStructByTags(params map[string]any, dest any) error {
 			if v, ok := params[mapTag]; ok {
 				// set a value for a field
 				if field.CanSet() {
-					field.Set(reflect.ValueOf(v))
+					if err := setField(field, v, mapTag); err != nil {
+						return err
+					}
 				}
 
 				// error when required field is missing
@@ -132,6 +140,95 @@ func MapToStructByTags(params map[string]any, dest any) error {
 	return nil
 }
 
+// setField sets a value to a field, parsing strings for int, uint, bool, float and time.Duration fields.
+// It returns an error instead of panicking when the value can't be assigned to the field.
+func setField(field reflect.Value, v any, key string) error {
+	if v == nil {
+		field.Set(reflect.Zero(field.Type()))
+		return nil
+	}
+
+	s, ok := v.(string)
+	if !ok || field.Kind() == reflect.String {
+		return assignField(field, v, key)
+	}
+
+	convErr := func(err error) error {
+		return fmt.Errorf("field '%s' can't convert %q to %s: %w", key, s, field.Type(), err)
+	}
+
+	switch {
+	case field.Type() == durationType:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return convErr(err)
+		}
+		field.SetInt(int64(d))
+
+	case field.CanInt():
+		n, err := strconv.ParseInt(s, 10, field.Type().Bits())
+		if err != nil {
+			return convErr(err)
+		}
+		field.SetInt(n)
+
+	case field.CanUint():
+		n, err := strconv.ParseUint(s, 10, field.Type().Bits())
+		if err != nil {
+			return convErr(err)
+		}
+		field.SetUint(n)
+
+	case field.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return convErr(err)
+		}
+		field.SetBool(b)
+
+	case field.CanFloat():
+		f, err := strconv.ParseFloat(s, field.Type().Bits())
+		if err != nil {
+			return convErr(err)
+		}
+		field.SetFloat(f)
+
+	default:
+		return assignField(field, v, key)
+	}
+
+	return nil
+}
+
+// assignField sets a value that is assignable to the field, or a number convertible to a numeric field.
+// Floats with a fraction are not converted to integer fields, since they would be truncated.
+func assignField(field reflect.Value, v any, key string) error {
+	rv := reflect.ValueOf(v)
+
+	switch {
+	case rv.Type().AssignableTo(field.Type()):
+		field.Set(rv)
+	case rv.CanFloat() && (field.CanInt() || field.CanUint()) && rv.Float() != math.Trunc(rv.Float()):
+		return fmt.Errorf("field '%s' expects %s, but got %v", key, field.Type(), v)
+	case isNumberKind(rv.Kind()) && isNumberKind(field.Kind()) && field.Type() != durationType:
+		field.Set(rv.Convert(field.Type()))
+	default:
+		return fmt.Errorf("field '%s' expects %s, but got %T", key, field.Type(), v)
+	}
+
+	return nil
+}
+
+func isNumberKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
 // converting from a struct to a map[string]any
 func StructToMapByTags(src any) (map[string]any, error) {
 	result := make(map[string]any)
@@ -150,11 +247,16 @@ func StructToMapByTags(src any) (map[string]any, error) {
 		fieldType := typ.Field(i)
 
 		// get the map tag
-		mapTag := fieldType.Tag.Get(tagMap)
+		mapTag, omitempty := parseMapTag(fieldType.Tag.Get(tagMap))
 		if mapTag == "" {
 			continue
 		}
 
+		// skip zero values with the omitempty option
+		if omitempty && isEmptyValue(field) {
+			continue
+		}
+
 		// when nested struct
 		if field.Kind() == reflect.Struct {
 			nestedMap, err := StructToMapByTags(field.Interface())
@@ -163,6 +265,26 @@ func StructToMapByTags(src any) (map[string]any, error) {
 			}
 			result[mapTag] = nestedMap
 
+			// when the field is a slice of structs or struct pointers
+		} else if isStructSlice(field.Type()) {
+			items := make([]any, 0, field.Len())
+			for j := 0; j < field.Len(); j++ {
+				elem := field.Index(j)
+				if elem.Kind() == reflect.Ptr {
+					if elem.IsNil() {
+						items = append(items, nil)
+						continue
+					}
+					elem = elem.Elem()
+				}
+				nestedMap, err := StructToMapByTags(elem.Interface())
+				if err != nil {
+					return nil, err
+				}
+				items = append(items, nestedMap)
+			}
+			result[mapTag] = items
+
 			// when the field is []byte
 		} else if field.Type() == reflect.TypeOf([]byte{}) {
 			if b, ok := field.Interface().([]byte); ok {
@@ -182,6 +304,45 @@ func StructToMapByTags(src any) (map[string]any, error) {
 	return result, nil
 }
 
+// parseMapTag splits a map tag like `map:"name,omitempty"` into the name and the omitempty option.
+func parseMapTag(tag string) (string, bool) {
+	name, opts, _ := strings.Cut(tag, ",")
+	omitempty := false
+	for _, opt := range strings.Split(opts, ",") {
+		if opt == optOmitempty {
+			omitempty = true
+		}
+	}
+	return name, omitempty
+}
+
+// isEmptyValue reports whether v is empty in the same way as encoding/json omitempty.
+// Structs are never empty.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64,
+		reflect.Interface, reflect.Pointer:
+		return v.IsZero()
+	}
+	return false
+}
+
+func isStructSlice(t reflect.Type) bool {
+	if t.Kind() != reflect.Slice {
+		return false
+	}
+	elem := t.Elem()
+	if elem.Kind() == reflect.Ptr {
+		elem = elem.Elem()
+	}
+	return elem.Kind() == reflect.Struct
+}
+
 func AssignStruct(pa ActionsParams, st any) error {
 	v := reflect.ValueOf(st).Elem()
 	t := v.Type()
@@ -190,7 +351,7 @@ func AssignStruct(pa ActionsParams, st any) error {
 	for i := 0; i < t.NumField(); i++ {
 		field := t.Field(i)
 		fType := field.Type
-		mapKey := field.Tag.Get("map")
+		mapKey, _ := parseMapTag(field.Tag.Get("map"))
 		va := field.Tag.Get("validate")
 		required := strings.Contains(va, "required")
 
@@ -227,16 +388,81 @@ func AssignStruct(pa ActionsParams, st any) error {
 	return nil
 }
 
+// cyclicValue is set instead of a map or a slice that contains itself
+const cyclicValue = "[Circular]"
+
+// truncatedValue is set instead of a map or a slice beyond the limits
+const truncatedValue = "[Truncated]"
+
+// Limits of FlattenInterface and UnflattenInterface, against blowups by huge request or response data.
+var (
+	FlattenMaxDepth = 64
+	FlattenMaxKeys  = 100000
+)
+
+type flattenState struct {
+	res      map[string]string
+	visiting map[uintptr]bool
+	full     bool
+	marked   bool
+}
+
 func FlattenInterface(i any) map[string]string {
-	return flattenIf(i, "")
+	st := &flattenState{res: make(map[string]string), visiting: map[uintptr]bool{}}
+	flattenIf(i, "", 0, st)
+	return st.res
 }
 
-func flattenIf(input any, prefix string) map[string]string {
-	res := make(map[string]string)
+// KV is a pair of a flat key and its value.
+type KV struct {
+	Key   string
+	Value string
+}
+
+// FlattenInterfaceSorted flattens the input like FlattenInterface, and returns the pairs sorted by key
+// for a deterministic output.
+func FlattenInterfaceSorted(i any) []KV {
+	flat := FlattenInterface(i)
+	kvs := make([]KV, 0, len(flat))
+	for k, v := range flat {
+		kvs = append(kvs, KV{Key: k, Value: v})
+	}
+	sort.Slice(kvs, func(a, b int) bool { return kvs[a].Key < kvs[b].Key })
+
+	return kvs
+}
+
+// flattenIf flattens the input recursively. The visiting holds the maps and slices being flattened
+// on the current path, so that a cycle is cut, while the same value referenced twice is flattened twice.
+// A map or a slice deeper than FlattenMaxDepth, or one in which the keys reach FlattenMaxKeys, is cut
+// with truncatedValue.
+func flattenIf(input any, prefix string, depth int, st *flattenState) {
+	if st.full {
+		return
+	}
 
 	if input == nil {
-		res[prefix] = ""
-		return res
+		st.set(prefix, "")
+		return
+	}
+
+	switch reflect.TypeOf(input).Kind() {
+	case reflect.Map, reflect.Slice:
+		v := reflect.ValueOf(input)
+		if v.Len() == 0 {
+			break
+		}
+		if depth > FlattenMaxDepth {
+			st.set(prefix, truncatedValue)
+			return
+		}
+		ptr := v.Pointer()
+		if st.visiting[ptr] {
+			st.set(prefix, cyclicValue)
+			return
+		}
+		st.visiting[ptr] = true
+		defer delete(st.visiting, ptr)
 	}
 
 	switch reflect.TypeOf(input).Kind() {
@@ -253,8 +479,10 @@ func flattenIf(input any, prefix string) map[string]string {
 			}
 
 			// Recursive calls handle nesting
-			for k, v := range flattenIf(inputMap.MapIndex(key).Interface(), strKey) {
-				res[k] = v
+			flattenIf(inputMap.MapIndex(key).Interface(), strKey, depth+1, st)
+			if st.full {
+				st.truncate(prefix)
+				return
 			}
 		}
 
@@ -266,36 +494,80 @@ func flattenIf(input any, prefix string) map[string]string {
 			if prefix != "" {
 				strKey = prefix + flatkey + strKey
 			}
-			for k, v := range flattenIf(inputSlice.Index(i).Interface(), strKey) {
-				res[k] = v
+			flattenIf(inputSlice.Index(i).Interface(), strKey, depth+1, st)
+			if st.full {
+				st.truncate(prefix)
+				return
 			}
 		}
 
 	default:
 		// If it is a basic type, it is stored as is.
-		res[prefix] = fmt.Sprintf("%v", input)
+		st.set(prefix, fmt.Sprintf("%v", input))
+	}
+}
+
+// set stores the value unless the keys reach FlattenMaxKeys.
+func (st *flattenState) set(key, value string) {
+	if len(st.res) >= FlattenMaxKeys {
+		st.full = true
+		return
 	}
+	st.res[key] = value
+}
 
-	return res
+// truncate marks the innermost map or slice that is cut by FlattenMaxKeys.
+func (st *flattenState) truncate(prefix string) {
+	if st.marked {
+		return
+	}
+	st.res[prefix] = truncatedValue
+	st.marked = true
 }
 
-// Recursively convert a map[string]string to a map[string]any
-func UnflattenInterface(flatMap map[string]string) map[string]any {
+// Recursively convert a map[string]string to a map[string]any.
+// Integer strings are converted to int, except the values of preserveKeys and of the keys nested under them.
+// Keys deeper than FlattenMaxDepth are cut with truncatedValue, and keys beyond FlattenMaxKeys are dropped.
+func UnflattenInterface(flatMap map[string]string, preserveKeys ...string) map[string]any {
 	result := make(map[string]any)
 
-	for key, value := range flatMap {
-		keys := strings.Split(key, flatkey)
-		nestMap(result, keys, value)
+	preserve := func(key string) bool {
+		for _, k := range preserveKeys {
+			if key == k || strings.HasPrefix(key, k+flatkey) {
+				return true
+			}
+		}
+		return false
+	}
+
+	// keys beyond the limit are dropped in sorted order, so that the same keys are kept every time
+	keys := make([]string, 0, len(flatMap))
+	for key := range flatMap {
+		keys = append(keys, key)
+	}
+	if len(keys) > FlattenMaxKeys {
+		sort.Strings(keys)
+		keys = keys[:FlattenMaxKeys]
+	}
+
+	for _, key := range keys {
+		value := flatMap[key]
+		nested := strings.Split(key, flatkey)
+		if len(nested) > FlattenMaxDepth+1 {
+			nested = nested[:FlattenMaxDepth+1]
+			value = truncatedValue
+		}
+		nestMap(result, nested, value, preserve(key))
 	}
 
 	return result
 }
 
 // A helper to set values for nested keys
-func nestMap(m map[string]any, keys []string, value string) {
+func nestMap(m map[string]any, keys []string, value string, preserve bool) {
 	if len(keys) == 1 {
 		// when it is the last key, set the value
-		if intValue, err := strconv.Atoi(value); err == nil {
+		if intValue, ok := StrictAtoi(value); ok && !preserve {
 			m[keys[0]] = intValue
 		} else {
 			m[keys[0]] = value
@@ -305,9 +577,24 @@ func nestMap(m map[string]any, keys []string, value string) {
 		if _, exists := m[keys[0]]; !exists {
 			m[keys[0]] = make(map[string]any)
 		}
+		// a value set already by a shorter key, like a truncated one, is kept
+		next, ok := m[keys[0]].(map[string]any)
+		if !ok {
+			return
+		}
 		// recursively set the next nested map
-		nestMap(m[keys[0]].(map[string]any), keys[1:], value)
+		nestMap(next, keys[1:], value, preserve)
+	}
+}
+
+// StrictAtoi converts a string to int only when the int converts back to the same string.
+// Values like zip codes "01234", "+1" or numbers overflowing int are kept as strings.
+func StrictAtoi(s string) (int, bool) {
+	n, err := strconv.Atoi(s)
+	if err != nil || strconv.Itoa(n) != s {
+		return 0, false
 	}
+	return n, true
 }
 
 func mustMarshalJSON(st string) map[string]any {
@@ -362,8 +649,11 @@ func EnvMap() map[string]string {
 }
 
 // AnyToString attempts to convert any type to a string.
+// Maps, slices and arrays are encoded as json, so that nested values are not lost. Nil is "nil".
 func AnyToString(value any) (string, bool) {
 	switch v := value.(type) {
+	case nil:
+		return "nil", true
 	case string:
 		return v, true
 	case bool:
@@ -379,9 +669,27 @@ func AnyToString(value any) (string, bool) {
 	case fmt.Stringer:
 		return v.String(), true
 	default:
-		if reflect.ValueOf(value).IsZero() {
+		rv := reflect.ValueOf(value)
+		switch rv.Kind() {
+		case reflect.Map, reflect.Slice:
+			if rv.IsNil() {
+				return "nil", true
+			}
+			return jsonString(value)
+		case reflect.Array:
+			return jsonString(value)
+		}
+		if rv.IsZero() {
 			return "nil", true
 		}
 		return "", false
 	}
 }
+
+func jsonString(value any) (string, bool) {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return "", false
+	}
+	return string(b), true
+}
@@ -3,6 +3,7 @@ package probe
 import (
 	"reflect"
 	"testing"
+	"time"
 )
 
 type TestStruct struct {
@@ -80,6 +81,262 @@ func TestMapToStructByTags_Required(t *testing.T) {
 	}
 }
 
+func TestMapToStructByTags_Coercion(t *testing.T) {
+	type coerce struct {
+		Int      int           `map:"int"`
+		Int64    int64         `map:"int64"`
+		Uint     uint          `map:"uint"`
+		Uint16   uint16        `map:"uint16"`
+		Bool     bool          `map:"bool"`
+		Float    float64       `map:"float"`
+		Duration time.Duration `map:"duration"`
+	}
+
+	tests := []struct {
+		name    string
+		params  map[string]any
+		expects coerce
+		err     string
+	}{
+		{
+			name:    "int from string",
+			params:  map[string]any{"int": "993", "int64": "-42"},
+			expects: coerce{Int: 993, Int64: -42},
+		},
+		{
+			name:    "uint from string",
+			params:  map[string]any{"uint": "993", "uint16": "65535"},
+			expects: coerce{Uint: 993, Uint16: 65535},
+		},
+		{
+			name:   "negative uint",
+			params: map[string]any{"uint": "-1"},
+			err:    `field 'uint' can't convert "-1" to uint: strconv.ParseUint: parsing "-1": invalid syntax`,
+		},
+		{
+			name:   "fractional int",
+			params: map[string]any{"int": "1.5"},
+			err:    `field 'int' can't convert "1.5" to int: strconv.ParseInt: parsing "1.5": invalid syntax`,
+		},
+		{
+			name:    "bool from string",
+			params:  map[string]any{"bool": "true"},
+			expects: coerce{Bool: true},
+		},
+		{
+			name:    "float from string",
+			params:  map[string]any{"float": "0.25"},
+			expects: coerce{Float: 0.25},
+		},
+		{
+			name:    "duration from string",
+			params:  map[string]any{"duration": "1m30s"},
+			expects: coerce{Duration: 90 * time.Second},
+		},
+		{
+			name:   "invalid int",
+			params: map[string]any{"int": "abc"},
+			err:    `field 'int' can't convert "abc" to int: strconv.ParseInt: parsing "abc": invalid syntax`,
+		},
+		{
+			name:   "invalid duration",
+			params: map[string]any{"duration": "soon"},
+			err:    `field 'duration' can't convert "soon" to time.Duration: time: invalid duration "soon"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := coerce{}
+			err := MapToStructByTags(tt.params, &got)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Errorf("expected error %s, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("MapToStructByTags error %s", err)
+			}
+			if !reflect.DeepEqual(got, tt.expects) {
+				t.Errorf("\nExpected:\n%#v\nGot:\n%#v", tt.expects, got)
+			}
+		})
+	}
+}
+
+func TestMapToStructByTags_TypeMismatch(t *testing.T) {
+	type mismatch struct {
+		Int      int           `map:"int"`
+		Float    float64       `map:"float"`
+		Name     string        `map:"name"`
+		Duration time.Duration `map:"duration"`
+		List     []string      `map:"list"`
+		Any      any           `map:"any"`
+	}
+
+	tests := []struct {
+		name    string
+		params  map[string]any
+		expects mismatch
+		err     string
+	}{
+		{
+			name:   "string to int field",
+			params: map[string]any{"int": "not-a-number"},
+			err:    `field 'int' can't convert "not-a-number" to int: strconv.ParseInt: parsing "not-a-number": invalid syntax`,
+		},
+		{
+			name:   "int to string field",
+			params: map[string]any{"name": 123},
+			err:    "field 'name' expects string, but got int",
+		},
+		{
+			name:   "map to slice field",
+			params: map[string]any{"list": map[string]any{"a": "b"}},
+			err:    "field 'list' expects []string, but got map[string]interface {}",
+		},
+		{
+			name:   "int to duration field",
+			params: map[string]any{"duration": 30},
+			err:    "field 'duration' expects time.Duration, but got int",
+		},
+		{
+			name:   "float with a fraction to int field",
+			params: map[string]any{"int": 1.5},
+			err:    "field 'int' expects int, but got 1.5",
+		},
+		{
+			name:    "numbers are converted",
+			params:  map[string]any{"int": float64(8), "float": 3},
+			expects: mismatch{Int: 8, Float: 3},
+		},
+		{
+			name:    "any and nil are assigned",
+			params:  map[string]any{"any": []any{1}, "name": nil},
+			expects: mismatch{Any: []any{1}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := mismatch{}
+			err := MapToStructByTags(tt.params, &got)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Errorf("expected error %s, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("MapToStructByTags error %s", err)
+			}
+			if !reflect.DeepEqual(got, tt.expects) {
+				t.Errorf("\nExpected:\n%#v\nGot:\n%#v", tt.expects, got)
+			}
+		})
+	}
+}
+
+func TestStructToMapByTags(t *testing.T) {
+	type item struct {
+		Name   string `map:"name"`
+		Hidden string
+	}
+	type list struct {
+		Title string  `map:"title"`
+		Items []item  `map:"items"`
+		Ptrs  []*item `map:"ptrs"`
+		Empty []item  `map:"empty"`
+	}
+
+	src := list{
+		Title: "probe",
+		Items: []item{{Name: "foo", Hidden: "x"}, {Name: "bar"}},
+		Ptrs:  []*item{{Name: "baz"}, nil},
+	}
+
+	expects := map[string]any{
+		"title": "probe",
+		"items": []any{
+			map[string]any{"name": "foo"},
+			map[string]any{"name": "bar"},
+		},
+		"ptrs": []any{
+			map[string]any{"name": "baz"},
+			nil,
+		},
+		"empty": []any{},
+	}
+
+	got, err := StructToMapByTags(src)
+	if err != nil {
+		t.Errorf("StructToMapByTags error %s", err)
+	}
+	if !reflect.DeepEqual(got, expects) {
+		t.Errorf("\nExpected:\n%#v\nGot:\n%#v", expects, got)
+	}
+
+	flat := FlattenInterface(got)
+	if flat["items__1__name"] != "bar" || flat["ptrs__0__name"] != "baz" {
+		t.Errorf("unexpected flatten result %#v", flat)
+	}
+}
+
+func TestStructToMapByTags_Omitempty(t *testing.T) {
+	type nested struct {
+		Name string `map:"name,omitempty"`
+	}
+	type omit struct {
+		String  string            `map:"string,omitempty"`
+		Int     int               `map:"int,omitempty"`
+		Float   float64           `map:"float,omitempty"`
+		Bool    bool              `map:"bool,omitempty"`
+		Bytes   []byte            `map:"bytes,omitempty"`
+		Map     map[string]string `map:"map,omitempty"`
+		Slice   []nested          `map:"slice,omitempty"`
+		Any     any               `map:"any,omitempty"`
+		Nested  nested            `map:"nested,omitempty"`
+		Keep    string            `map:"keep"`
+		KeepInt int               `map:"keep_int"`
+	}
+
+	got, err := StructToMapByTags(omit{})
+	if err != nil {
+		t.Errorf("StructToMapByTags error %s", err)
+	}
+	expects := map[string]any{
+		"nested":   map[string]any{},
+		"keep":     "",
+		"keep_int": 0,
+	}
+	if !reflect.DeepEqual(got, expects) {
+		t.Errorf("\nExpected:\n%#v\nGot:\n%#v", expects, got)
+	}
+
+	got, err = StructToMapByTags(omit{String: "s", Int: 1, Bool: true, Bytes: []byte("b"), Nested: nested{Name: "n"}})
+	if err != nil {
+		t.Errorf("StructToMapByTags error %s", err)
+	}
+	expects = map[string]any{
+		"string":   "s",
+		"int":      1,
+		"bool":     true,
+		"bytes":    "b",
+		"nested":   map[string]any{"name": "n"},
+		"keep":     "",
+		"keep_int": 0,
+	}
+	if !reflect.DeepEqual(got, expects) {
+		t.Errorf("\nExpected:\n%#v\nGot:\n%#v", expects, got)
+	}
+
+	var dest omit
+	if err := MapToStructByTags(map[string]any{"string": "s"}, &dest); err != nil || dest.String != "s" {
+		t.Errorf("MapToStructByTags with omitempty tag: %#v, %v", dest, err)
+	}
+}
+
 func TestFlattenInterface(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -129,12 +386,177 @@ func TestFlattenInterface(t *testing.T) {
 	}
 }
 
+func TestFlattenInterfaceCycle(t *testing.T) {
+	self := map[string]any{"name": "self"}
+	self["self"] = self
+	list := []any{"a", nil}
+	list[1] = list
+	shared := map[string]any{"id": "1"}
+
+	data := map[string]any{
+		"map":  self,
+		"list": list,
+		"a":    shared,
+		"b":    shared,
+	}
+	expects := map[string]string{
+		"map__name": "self",
+		"map__self": cyclicValue,
+		"list__0":   "a",
+		"list__1":   cyclicValue,
+		"a__id":     "1",
+		"b__id":     "1",
+	}
+
+	if got := FlattenInterface(data); !reflect.DeepEqual(got, expects) {
+		t.Errorf("\nExpected:\n%#v\nGot:\n%#v", expects, got)
+	}
+}
+
+func TestFlattenInterfaceSorted(t *testing.T) {
+	data := map[string]any{
+		"res": map[string]any{"code": 200, "headers": map[string]any{"Date": "today", "Content-Type": "text/plain"}},
+		"req": map[string]any{"url": "http://localhost", "method": "GET"},
+		"ids": []any{"b", "a"},
+	}
+	expects := []KV{
+		{Key: "ids__0", Value: "b"},
+		{Key: "ids__1", Value: "a"},
+		{Key: "req__method", Value: "GET"},
+		{Key: "req__url", Value: "http://localhost"},
+		{Key: "res__code", Value: "200"},
+		{Key: "res__headers__Content-Type", Value: "text/plain"},
+		{Key: "res__headers__Date", Value: "today"},
+	}
+
+	// map iteration order differs every time
+	for i := 0; i < 20; i++ {
+		if got := FlattenInterfaceSorted(data); !reflect.DeepEqual(got, expects) {
+			t.Fatalf("\nExpected:\n%#v\nGot:\n%#v", expects, got)
+		}
+	}
+}
+
+func setFlattenLimits(t *testing.T, depth, keys int) {
+	t.Helper()
+	origDepth, origKeys := FlattenMaxDepth, FlattenMaxKeys
+	FlattenMaxDepth, FlattenMaxKeys = depth, keys
+	t.Cleanup(func() { FlattenMaxDepth, FlattenMaxKeys = origDepth, origKeys })
+}
+
+func TestFlattenInterfaceLimits(t *testing.T) {
+	t.Run("depth", func(t *testing.T) {
+		setFlattenLimits(t, 2, 100)
+
+		// nested 10000 levels
+		deep := map[string]any{"v": "bottom"}
+		for i := 0; i < 10000; i++ {
+			deep = map[string]any{"n": deep}
+		}
+		expects := map[string]string{"n__n__n": truncatedValue}
+		if got := FlattenInterface(deep); !reflect.DeepEqual(got, expects) {
+			t.Errorf("\nExpected:\n%#v\nGot:\n%#v", expects, got)
+		}
+	})
+
+	t.Run("keys", func(t *testing.T) {
+		setFlattenLimits(t, 64, 10)
+
+		list := make([]any, 1000)
+		for i := range list {
+			list[i] = i
+		}
+		got := FlattenInterface(map[string]any{"list": list})
+		if len(got) != 11 || got["list"] != truncatedValue {
+			t.Errorf("expected 10 keys and the truncated marker, got %d keys: %#v", len(got), got)
+		}
+	})
+}
+
+func TestUnflattenInterfaceLimits(t *testing.T) {
+	t.Run("depth", func(t *testing.T) {
+		setFlattenLimits(t, 2, 100)
+
+		got := UnflattenInterface(map[string]string{
+			"a__b__c__d__e": "1",
+			"a__b__c__x":    "2",
+			"a__y":          "3",
+		})
+		expects := map[string]any{"a": map[string]any{"b": map[string]any{"c": truncatedValue}, "y": 3}}
+		if !reflect.DeepEqual(got, expects) {
+			t.Errorf("\nExpected:\n%#v\nGot:\n%#v", expects, got)
+		}
+	})
+
+	t.Run("keys", func(t *testing.T) {
+		setFlattenLimits(t, 64, 2)
+
+		got := UnflattenInterface(map[string]string{"c": "3", "a": "1", "b": "2"})
+		expects := map[string]any{"a": 1, "b": 2}
+		if !reflect.DeepEqual(got, expects) {
+			t.Errorf("\nExpected:\n%#v\nGot:\n%#v", expects, got)
+		}
+	})
+}
+
 func TestUnflattenInterface(t *testing.T) {
 	tests := []struct {
-		name    string
-		expects map[string]any
-		data    map[string]string
+		name     string
+		expects  map[string]any
+		data     map[string]string
+		preserve []string
 	}{
+		{
+			name: "numeric strings that do not round-trip are kept as strings",
+			expects: map[string]any{
+				"zip":     "007",
+				"version": "0.10",
+				"big":     "12345678901234567890",
+				"plus":    "+1",
+				"zero":    0,
+				"neg":     -12,
+				"num":     123,
+			},
+			data: map[string]string{
+				"zip":     "007",
+				"version": "0.10",
+				"big":     "12345678901234567890",
+				"plus":    "+1",
+				"zero":    "0",
+				"neg":     "-12",
+				"num":     "123",
+			},
+		},
+		{
+			name: "preserved keys are not converted",
+			expects: map[string]any{
+				"body": map[string]any{
+					"phone": "12345",
+					"id":    12345,
+				},
+			},
+			data: map[string]string{
+				"body__phone": "12345",
+				"body__id":    "12345",
+			},
+			preserve: []string{"body__phone"},
+		},
+		{
+			name: "keys nested under preserved keys are not converted",
+			expects: map[string]any{
+				"expr": map[string]any{
+					"0": "1",
+					"1": "count > 3",
+				},
+				"count": 5,
+			},
+			data: map[string]string{
+				"expr__0": "1",
+				"expr__1": "count > 3",
+				"count":   "5",
+			},
+			preserve: []string{"expr"},
+		},
 		{
 			name: "nest maps if there are two consecutive underscore keys",
 			expects: map[string]any{
@@ -169,7 +591,7 @@ func TestUnflattenInterface(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := UnflattenInterface(tt.data)
+			got := UnflattenInterface(tt.data, tt.preserve...)
 
 			if !reflect.DeepEqual(got, tt.expects) {
 				t.Errorf("\nExpected:\n%#v\nGot:\n%#v", tt.expects, got)
@@ -177,3 +599,39 @@ func TestUnflattenInterface(t *testing.T) {
 		})
 	}
 }
+
+func TestAnyToString(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    any
+		expected string
+		ok       bool
+	}{
+		{name: "string", value: "abc", expected: "abc", ok: true},
+		{name: "int", value: 42, expected: "42", ok: true},
+		{name: "float", value: 1.5, expected: "1.5", ok: true},
+		{name: "bytes", value: []byte("abc"), expected: "abc", ok: true},
+		{name: "nil", value: nil, expected: "nil", ok: true},
+		{name: "nil map", value: map[string]any(nil), expected: "nil", ok: true},
+		{name: "int slice", value: []int{1, 2, 3}, expected: "[1,2,3]", ok: true},
+		{name: "array", value: [2]string{"a", "b"}, expected: `["a","b"]`, ok: true},
+		{name: "map", value: map[string]any{"b": 2, "a": "x"}, expected: `{"a":"x","b":2}`, ok: true},
+		{
+			name:     "nested",
+			value:    map[string]any{"items": []any{map[string]any{"id": 1, "tags": []string{"x"}}}},
+			expected: `{"items":[{"id":1,"tags":["x"]}]}`,
+			ok:       true,
+		},
+		{name: "unencodable", value: map[string]any{"ch": make(chan int)}, expected: "", ok: false},
+		{name: "struct", value: struct{ A int }{A: 1}, expected: "", ok: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := AnyToString(tt.value)
+			if got != tt.expected || ok != tt.ok {
+				t.Errorf("expected %q (%t), got %q (%t)", tt.expected, tt.ok, got, ok)
+			}
+		})
+	}
+}
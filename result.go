@@ -0,0 +1,477 @@
+package probe
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+const (
+	StatusSuccess = "success"
+	StatusFailure = "failure"
+	StatusError   = "error"
+	StatusNoTest  = "no-test"
+	StatusWarning = "warning"
+	StatusSlow    = "slow"
+	StatusSkipped = "skipped"
+	StatusAborted = "aborted"
+
+	StoppedByUntil    = "until"
+	StoppedByMaxCount = "max_count"
+
+	OutputFormatJSON  = "json"
+	OutputFormatJUnit = "junit"
+)
+
+func IsOutputFormat(f string) bool {
+	return f == "" || f == OutputFormatJSON || f == OutputFormatJUnit
+}
+
+type StepResult struct {
+	Index     int           `json:"index"`
+	Name      string        `json:"name"`
+	Uses      string        `json:"uses"`
+	Status    string        `json:"status"`
+	Message   string        `json:"message,omitempty"`
+	Attempts  int           `json:"attempts,omitempty"`
+	Throttled bool          `json:"throttled,omitempty"`
+	StartTime time.Time     `json:"start_time"`
+	Duration  time.Duration `json:"duration"`
+}
+
+type JobResult struct {
+	Name       string       `json:"name"`
+	Failed     bool         `json:"failed"`
+	Skipped    bool         `json:"skipped,omitempty"`
+	Aborted    bool         `json:"aborted,omitempty"`
+	Iterations int          `json:"iterations,omitempty"`
+	StoppedBy  string       `json:"stopped_by,omitempty"`
+	StartTime  time.Time    `json:"start_time"`
+	EndTime    time.Time    `json:"end_time"`
+	Steps      []StepResult `json:"steps"`
+}
+
+func (j *JobResult) AddStep(s StepResult) {
+	if j == nil {
+		return
+	}
+	j.Steps = append(j.Steps, s)
+}
+
+// Status returns skipped, failure, aborted or success.
+func (j *JobResult) Status() string {
+	switch {
+	case j.Skipped:
+		return StatusSkipped
+	case j.Failed:
+		return StatusFailure
+	case j.Aborted:
+		return StatusAborted
+	default:
+		return StatusSuccess
+	}
+}
+
+func (j *JobResult) Duration() time.Duration {
+	return j.EndTime.Sub(j.StartTime)
+}
+
+// Result holds the results of all jobs in a workflow run.
+// Jobs are appended in order of completion.
+type Result struct {
+	Name      string       `json:"name"`
+	StartTime time.Time    `json:"start_time"`
+	EndTime   time.Time    `json:"end_time"`
+	Jobs      []*JobResult `json:"jobs"`
+	mu        sync.Mutex
+}
+
+func (r *Result) Add(j *JobResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Jobs = append(r.Jobs, j)
+}
+
+func (r *Result) Duration() time.Duration {
+	return r.EndTime.Sub(r.StartTime)
+}
+
+// ExitStatus returns ExitActionError when an action failed to run, ExitTestFailure when a job failed otherwise,
+// and ExitSuccess when all jobs passed.
+func (r *Result) ExitStatus() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	status := ExitSuccess
+	for _, j := range r.Jobs {
+		if !j.Failed {
+			continue
+		}
+		status = max(status, ExitTestFailure)
+		for _, s := range j.Steps {
+			if s.Status == StatusError {
+				return ExitActionError
+			}
+		}
+	}
+
+	return status
+}
+
+// ReadResultFile reads the result written as json by a previous run.
+func ReadResultFile(path string) (*Result, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &Result{}
+	if err := json.Unmarshal(b, r); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return r, nil
+}
+
+// FailedJobs returns names of jobs that failed or were aborted. A repeated job is included when any run of it is.
+func (r *Result) FailedJobs() map[string]bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	names := map[string]bool{}
+	for _, j := range r.Jobs {
+		if st := j.Status(); st == StatusFailure || st == StatusAborted {
+			names[j.Name] = true
+		}
+	}
+	return names
+}
+
+// Write renders the result in the given format. Nothing is written for the console format,
+// because the console report is printed while the workflow runs.
+func (r *Result) Write(w io.Writer, format string) error {
+	switch format {
+	case OutputFormatJSON:
+		return r.WriteJSON(w)
+	case OutputFormatJUnit:
+		return r.WriteJUnit(w)
+	}
+	return nil
+}
+
+func (r *Result) WriteJSON(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteFooter renders the number of steps by status and the duration of the run in a line.
+// Steps without test count as passed, and slow steps and action errors count as failed.
+func (r *Result) WriteFooter(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var total, passed, failed, warning, aborted int
+	for _, j := range r.Jobs {
+		for _, s := range j.Steps {
+			total++
+			switch s.Status {
+			case StatusSuccess, StatusNoTest:
+				passed++
+			case StatusWarning:
+				warning++
+			case StatusAborted:
+				aborted++
+			default:
+				failed++
+			}
+		}
+	}
+
+	counts := []string{color.GreenString("%d passed", passed)}
+	if failed > 0 {
+		counts = append(counts, color.RedString("%d failed", failed))
+	}
+	if warning > 0 {
+		counts = append(counts, color.YellowString("%d warning", warning))
+	}
+	if aborted > 0 {
+		counts = append(counts, color.HiBlackString("%d aborted", aborted))
+	}
+	_, err := fmt.Fprintf(w, "\n%d steps: %s %s\n", total, strings.Join(counts, ", "),
+		color.HiBlackString("(%s)", r.Duration().Round(time.Millisecond)))
+	return err
+}
+
+const timelineWidth = 40
+
+// WriteTimeline renders each step as a bar placed at its start offset from the workflow start,
+// with the length of its duration.
+func (r *Result) WriteTimeline(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	total := r.Duration()
+	nameWidth := 0
+	for _, j := range r.Jobs {
+		for _, s := range j.Steps {
+			nameWidth = max(nameWidth, len(s.Name))
+		}
+	}
+
+	cols := func(d time.Duration) int {
+		if total <= 0 {
+			return 0
+		}
+		return int(float64(d) / float64(total) * timelineWidth)
+	}
+
+	if _, err := fmt.Fprintf(w, "Timeline (total %s)\n", total); err != nil {
+		return err
+	}
+	for _, j := range r.Jobs {
+		if _, err := fmt.Fprintf(w, "%s\n", j.Name); err != nil {
+			return err
+		}
+		for _, s := range j.Steps {
+			offset := s.StartTime.Sub(r.StartTime)
+			pos := min(cols(offset), timelineWidth-1)
+			length := min(max(cols(s.Duration), 1), timelineWidth-pos)
+			bar := strings.Repeat(" ", pos) + strings.Repeat("█", length) + strings.Repeat(" ", timelineWidth-pos-length)
+			if _, err := fmt.Fprintf(w, "%2d. %-*s │%s│ +%s %s\n", s.Index, nameWidth, s.Name, bar, offset, s.Duration); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Errors   int              `xml:"errors,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Errors    int             `xml:"errors,attr"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	Cases     []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Body    string `xml:",chardata"`
+}
+
+func junitSeconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+// WriteJUnit renders each job as a testsuite and each step as a testcase.
+func (r *Result) WriteJUnit(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	suites := junitTestSuites{
+		Name: r.Name,
+		Time: junitSeconds(r.Duration()),
+	}
+
+	for _, j := range r.Jobs {
+		suite := junitTestSuite{
+			Name:      j.Name,
+			Tests:     len(j.Steps),
+			Time:      junitSeconds(j.Duration()),
+			Timestamp: j.StartTime.Format(time.RFC3339),
+		}
+		for _, s := range j.Steps {
+			tc := junitTestCase{
+				Name:      fmt.Sprintf("%d. %s", s.Index, s.Name),
+				Classname: j.Name,
+				Time:      junitSeconds(s.Duration),
+			}
+			switch s.Status {
+			case StatusFailure:
+				suite.Failures++
+				tc.Failure = &junitMessage{Message: "test failed", Body: s.Message}
+			case StatusSlow:
+				suite.Failures++
+				tc.Failure = &junitMessage{Message: "response time exceeded", Body: s.Message}
+			case StatusError:
+				suite.Errors++
+				tc.Error = &junitMessage{Message: "action error", Body: s.Message}
+			}
+			suite.Cases = append(suite.Cases, tc)
+		}
+		suites.Tests += suite.Tests
+		suites.Failures += suite.Failures
+		suites.Errors += suite.Errors
+		suites.Suites = append(suites.Suites, suite)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(suites); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// StepSummary aggregates the durations of the same step across repeated jobs.
+type StepSummary struct {
+	Job   string        `json:"job"`
+	Index int           `json:"index"`
+	Name  string        `json:"name"`
+	Count int           `json:"count"`
+	Min   time.Duration `json:"min"`
+	Max   time.Duration `json:"max"`
+	Mean  time.Duration `json:"mean"`
+	P50   time.Duration `json:"p50"`
+	P95   time.Duration `json:"p95"`
+}
+
+// Summary returns the timing summary of each step, sorted by job name and step index.
+func (r *Result) Summary() []StepSummary {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	type key struct {
+		job string
+		idx int
+	}
+	names := map[key]string{}
+	durations := map[key][]time.Duration{}
+	keys := []key{}
+
+	for _, j := range r.Jobs {
+		for _, s := range j.Steps {
+			k := key{job: j.Name, idx: s.Index}
+			if _, ok := durations[k]; !ok {
+				keys = append(keys, k)
+				names[k] = s.Name
+			}
+			durations[k] = append(durations[k], s.Duration)
+		}
+	}
+
+	sort.Slice(keys, func(a, b int) bool {
+		if keys[a].job != keys[b].job {
+			return keys[a].job < keys[b].job
+		}
+		return keys[a].idx < keys[b].idx
+	})
+
+	summaries := make([]StepSummary, 0, len(keys))
+	for _, k := range keys {
+		ds := durations[k]
+		sort.Slice(ds, func(a, b int) bool { return ds[a] < ds[b] })
+
+		var total time.Duration
+		for _, d := range ds {
+			total += d
+		}
+
+		summaries = append(summaries, StepSummary{
+			Job:   k.job,
+			Index: k.idx,
+			Name:  names[k],
+			Count: len(ds),
+			Min:   ds[0],
+			Max:   ds[len(ds)-1],
+			Mean:  total / time.Duration(len(ds)),
+			P50:   percentile(ds, 50),
+			P95:   percentile(ds, 95),
+		})
+	}
+
+	return summaries
+}
+
+// percentile returns the nearest-rank percentile of sorted durations.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func (r *Result) WriteSummaryJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r.Summary())
+}
+
+// WriteSummaryCSV writes the summary with durations in milliseconds.
+func (r *Result) WriteSummaryCSV(w io.Writer) error {
+	ms := func(d time.Duration) string {
+		return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64)
+	}
+
+	cw := csv.NewWriter(w)
+	header := []string{"job", "index", "name", "count", "min_ms", "max_ms", "mean_ms", "p50_ms", "p95_ms"}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for _, s := range r.Summary() {
+		row := []string{s.Job, strconv.Itoa(s.Index), s.Name, strconv.Itoa(s.Count),
+			ms(s.Min), ms(s.Max), ms(s.Mean), ms(s.P50), ms(s.P95)}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+
+	return cw.Error()
+}
+
+// CreateSummaryFile creates the summary file at path, which must have the .csv or .json extension.
+func CreateSummaryFile(path string) (*os.File, error) {
+	ext := filepath.Ext(path)
+	if ext != ".csv" && ext != ".json" {
+		return nil, fmt.Errorf("summary file must have .csv or .json extension: %s", path)
+	}
+	return os.Create(path)
+}
+
+// WriteSummaryFile writes the summary to the file created by CreateSummaryFile, as csv or json by the extension.
+func (r *Result) WriteSummaryFile(f *os.File) error {
+	if filepath.Ext(f.Name()) == ".csv" {
+		return r.WriteSummaryCSV(f)
+	}
+	return r.WriteSummaryJSON(f)
+}
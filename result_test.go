@@ -0,0 +1,180 @@
+package probe
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newTestResult() *Result {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	return &Result{
+		Name:      "Test",
+		StartTime: start,
+		EndTime:   start.Add(3 * time.Second),
+		Jobs: []*JobResult{
+			{
+				Name:      "Job",
+				Failed:    true,
+				StartTime: start,
+				EndTime:   start.Add(2 * time.Second),
+				Steps: []StepResult{
+					{Index: 0, Name: "Get", Uses: "http", Status: StatusSuccess, StartTime: start, Duration: 500 * time.Millisecond},
+					{Index: 1, Name: "Post", Uses: "http", Status: StatusFailure, Message: "response: 500", StartTime: start.Add(500 * time.Millisecond), Duration: time.Second},
+					{Index: 2, Name: "Hello", Uses: "hello", Status: StatusError, Message: "boom", StartTime: start.Add(1500 * time.Millisecond), Duration: 0},
+				},
+			},
+		},
+	}
+}
+
+func TestResultWriteJUnit(t *testing.T) {
+	var buf bytes.Buffer
+	if err := newTestResult().Write(&buf, OutputFormatJUnit); err != nil {
+		t.Fatalf("WriteJUnit error %s", err)
+	}
+
+	expected := `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites name="Test" tests="3" failures="1" errors="1" time="3.000">
+  <testsuite name="Job" tests="3" failures="1" errors="1" time="2.000" timestamp="2025-01-01T00:00:00Z">
+    <testcase name="0. Get" classname="Job" time="0.500"></testcase>
+    <testcase name="1. Post" classname="Job" time="1.000">
+      <failure message="test failed">response: 500</failure>
+    </testcase>
+    <testcase name="2. Hello" classname="Job" time="0.000">
+      <error message="action error">boom</error>
+    </testcase>
+  </testsuite>
+</testsuites>
+`
+	if buf.String() != expected {
+		t.Errorf("\nExpected:\n%s\nGot:\n%s", expected, buf.String())
+	}
+}
+
+func TestResultWriteJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := newTestResult().Write(&buf, OutputFormatJSON); err != nil {
+		t.Fatalf("WriteJSON error %s", err)
+	}
+
+	var got Result
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("unmarshal error %s", err)
+	}
+	if len(got.Jobs) != 1 || len(got.Jobs[0].Steps) != 3 {
+		t.Fatalf("unexpected jobs %#v", got.Jobs)
+	}
+	if got.Jobs[0].Steps[1].Status != StatusFailure || got.Jobs[0].Steps[1].Duration != time.Second {
+		t.Errorf("unexpected step %#v", got.Jobs[0].Steps[1])
+	}
+}
+
+func TestResultWriteConsole(t *testing.T) {
+	var buf bytes.Buffer
+	if err := newTestResult().Write(&buf, ""); err != nil {
+		t.Fatalf("Write error %s", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("expected nothing written, got %s", buf.String())
+	}
+}
+
+func TestResultExitStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		jobs     []*JobResult
+		expected int
+	}{
+		{
+			name:     "success",
+			jobs:     []*JobResult{{Steps: []StepResult{{Status: StatusSuccess}}}},
+			expected: ExitSuccess,
+		},
+		{
+			name:     "test failure",
+			jobs:     []*JobResult{{Failed: true, Steps: []StepResult{{Status: StatusFailure}}}},
+			expected: ExitTestFailure,
+		},
+		{
+			name: "action error",
+			jobs: []*JobResult{
+				{Failed: true, Steps: []StepResult{{Status: StatusFailure}}},
+				{Failed: true, Steps: []StepResult{{Status: StatusError}}},
+			},
+			expected: ExitActionError,
+		},
+		{
+			name:     "warning only",
+			jobs:     []*JobResult{{Steps: []StepResult{{Status: StatusWarning}}}},
+			expected: ExitSuccess,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &Result{Jobs: tt.jobs}
+			if got := r.ExitStatus(); got != tt.expected {
+				t.Errorf("expected %d, got %d", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestResultWriteTimeline(t *testing.T) {
+	var buf bytes.Buffer
+	if err := newTestResult().WriteTimeline(&buf); err != nil {
+		t.Fatalf("WriteTimeline error %s", err)
+	}
+
+	expected := `Timeline (total 3s)
+Job
+ 0. Get   │██████                                  │ +0s 500ms
+ 1. Post  │      █████████████                     │ +500ms 1s
+ 2. Hello │                    █                   │ +1.5s 0s
+`
+	if buf.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+}
+
+func TestResultSummary(t *testing.T) {
+	r := &Result{}
+	for _, ms := range []int{30, 10, 20, 40, 50} {
+		r.Add(&JobResult{
+			Name: "Job",
+			Steps: []StepResult{
+				{Index: 0, Name: "Get", Duration: time.Duration(ms) * time.Millisecond},
+			},
+		})
+	}
+
+	got := r.Summary()
+	expected := []StepSummary{
+		{
+			Job:   "Job",
+			Index: 0,
+			Name:  "Get",
+			Count: 5,
+			Min:   10 * time.Millisecond,
+			Max:   50 * time.Millisecond,
+			Mean:  30 * time.Millisecond,
+			P50:   30 * time.Millisecond,
+			P95:   50 * time.Millisecond,
+		},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("\nExpected:\n%#v\nGot:\n%#v", expected, got)
+	}
+
+	var buf bytes.Buffer
+	if err := r.WriteSummaryCSV(&buf); err != nil {
+		t.Fatalf("WriteSummaryCSV error %s", err)
+	}
+	csv := "job,index,name,count,min_ms,max_ms,mean_ms,p50_ms,p95_ms\nJob,0,Get,5,10.000,50.000,30.000,30.000,50.000\n"
+	if buf.String() != csv {
+		t.Errorf("\nExpected:\n%s\nGot:\n%s", csv, buf.String())
+	}
+}
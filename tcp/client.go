@@ -0,0 +1,178 @@
+package tcp
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/linyows/probe"
+)
+
+const bannerBufferSize = 4096
+
+type Req struct {
+	Host        string        `map:"host" validate:"required"`
+	Port        int           `map:"port" validate:"required"`
+	Protocol    string        `map:"protocol"`
+	Send        string        `map:"send"`
+	Expect      string        `map:"expect"`
+	Timeout     time.Duration `map:"timeout"`
+	ReadTimeout time.Duration `map:"read_timeout"`
+	LocalAddr   string        `map:"local_addr"`
+	cb          *Callback
+}
+
+type Res struct {
+	Open        bool          `map:"open"`
+	Banner      string        `map:"banner"`
+	Matched     bool          `map:"matched"`
+	ConnectTime time.Duration `map:"connect_time"`
+	RT          time.Duration `map:"rt"`
+	LocalAddr   string        `map:"local_addr,omitempty"`
+	Error       string        `map:"error,omitempty"`
+}
+
+type Result struct {
+	Req Req `map:"req"`
+	Res Res `map:"res"`
+}
+
+func NewReq() *Req {
+	return &Req{
+		Protocol:    "tcp",
+		Timeout:     5 * time.Second,
+		ReadTimeout: 2 * time.Second,
+	}
+}
+
+// Do connects to the port, sends the payload if any, and reads a banner.
+// A connection failure is not an error but reported as `open: false`. For udp, the port is
+// reported as open unless the peer refuses it, because udp has no handshake.
+func (r *Req) Do() (*Result, error) {
+	if r.Host == "" {
+		return nil, errors.New("Req.Host is required")
+	}
+	if r.Port == 0 {
+		return nil, errors.New("Req.Port is required")
+	}
+	if r.Protocol != "tcp" && r.Protocol != "udp" {
+		return nil, errors.New("Req.Protocol must be tcp or udp")
+	}
+
+	addr := net.JoinHostPort(r.Host, strconv.Itoa(r.Port))
+
+	d := &net.Dialer{Timeout: r.Timeout}
+	if r.LocalAddr != "" {
+		local, err := probe.LocalAddr(r.Protocol, r.LocalAddr)
+		if err != nil {
+			return nil, err
+		}
+		d.LocalAddr = local
+	}
+
+	// callback
+	if r.cb != nil && r.cb.before != nil {
+		r.cb.before(r.Protocol, addr)
+	}
+
+	result := &Result{Req: *r}
+	start := time.Now()
+
+	conn, err := d.Dial(r.Protocol, addr)
+	result.Res.ConnectTime = time.Since(start)
+	if err != nil {
+		result.Res.Error = err.Error()
+		result.Res.RT = time.Since(start)
+		return result, nil
+	}
+	defer conn.Close()
+	result.Res.Open = true
+	result.Res.LocalAddr = conn.LocalAddr().String()
+
+	if r.Send != "" {
+		if err := conn.SetWriteDeadline(time.Now().Add(r.ReadTimeout)); err != nil {
+			return nil, err
+		}
+		if _, err := conn.Write([]byte(r.Send)); err != nil {
+			result.Res.Error = err.Error()
+		}
+	}
+
+	if err := conn.SetReadDeadline(time.Now().Add(r.ReadTimeout)); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, bannerBufferSize)
+	n, err := conn.Read(buf)
+	result.Res.Banner = string(buf[:n])
+	if err != nil && !isTimeout(err) {
+		result.Res.Error = err.Error()
+		if r.Protocol == "udp" {
+			result.Res.Open = false
+		}
+	}
+
+	if r.Expect != "" {
+		result.Res.Matched = strings.Contains(result.Res.Banner, r.Expect)
+	}
+	result.Res.RT = time.Since(start)
+
+	// callback
+	if r.cb != nil && r.cb.after != nil {
+		r.cb.after(&result.Res)
+	}
+
+	return result, nil
+}
+
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+type Option func(*Callback)
+
+type Callback struct {
+	before func(network, addr string)
+	after  func(res *Res)
+}
+
+func Request(data map[string]string, opts ...Option) (map[string]string, error) {
+	m := probe.UnflattenInterface(data, "send", "expect")
+	r := NewReq()
+
+	cb := &Callback{}
+	for _, opt := range opts {
+		opt(cb)
+	}
+	r.cb = cb
+
+	if err := probe.MapToStructByTags(m, r); err != nil {
+		return map[string]string{}, err
+	}
+
+	ret, err := r.Do()
+	if err != nil {
+		return map[string]string{}, err
+	}
+
+	mapRet, err := probe.StructToMapByTags(ret)
+	if err != nil {
+		return map[string]string{}, err
+	}
+
+	return probe.FlattenInterface(mapRet), nil
+}
+
+func WithBefore(f func(network, addr string)) Option {
+	return func(c *Callback) {
+		c.before = f
+	}
+}
+
+func WithAfter(f func(res *Res)) Option {
+	return func(c *Callback) {
+		c.after = f
+	}
+}
@@ -0,0 +1,140 @@
+package tcp
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func listenBanner(t *testing.T, banner string) (string, int) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte(banner))
+			conn.Close()
+		}
+	}()
+
+	addr := ln.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port
+}
+
+func TestDoTCPBanner(t *testing.T) {
+	host, port := listenBanner(t, "220 mx.example.com ESMTP\r\n")
+
+	req := NewReq()
+	req.Host = host
+	req.Port = port
+	req.Expect = "ESMTP"
+
+	got, err := req.Do()
+	if err != nil {
+		t.Fatalf("got error %s", err)
+	}
+	if !got.Res.Open || !got.Res.Matched || got.Res.Banner != "220 mx.example.com ESMTP\r\n" {
+		t.Errorf("unexpected result %#v", got.Res)
+	}
+}
+
+func TestDoLocalAddr(t *testing.T) {
+	host, port := listenBanner(t, "hello")
+
+	req := NewReq()
+	req.Host = host
+	req.Port = port
+	req.LocalAddr = "127.0.0.1"
+
+	got, err := req.Do()
+	if err != nil {
+		t.Fatalf("got error %s", err)
+	}
+	if ip, _, _ := net.SplitHostPort(got.Res.LocalAddr); ip != "127.0.0.1" {
+		t.Errorf("expected local address 127.0.0.1, got %s", got.Res.LocalAddr)
+	}
+
+	req.LocalAddr = "192.0.2.1"
+	if _, err := req.Do(); err == nil || err.Error() != "local_addr 192.0.2.1 is not assigned to any interface" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestDoTCPClosed(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+
+	req := NewReq()
+	req.Host = "127.0.0.1"
+	req.Port = port
+
+	got, err := req.Do()
+	if err != nil {
+		t.Fatalf("got error %s", err)
+	}
+	if got.Res.Open || got.Res.Error == "" {
+		t.Errorf("expected closed port, got %#v", got.Res)
+	}
+}
+
+func TestDoUDPEcho(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	go func() {
+		buf := make([]byte, 1024)
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		pc.WriteTo(buf[:n], addr)
+	}()
+
+	req := NewReq()
+	req.Host = "127.0.0.1"
+	req.Port = pc.LocalAddr().(*net.UDPAddr).Port
+	req.Protocol = "udp"
+	req.Send = "hello"
+	req.Expect = "hello"
+	req.ReadTimeout = time.Second
+
+	got, err := req.Do()
+	if err != nil {
+		t.Fatalf("got error %s", err)
+	}
+	if !got.Res.Open || !got.Res.Matched {
+		t.Errorf("unexpected result %#v", got.Res)
+	}
+}
+
+func TestRequest(t *testing.T) {
+	host, port := listenBanner(t, "SSH-2.0-OpenSSH_9.6\r\n")
+
+	got, err := Request(map[string]string{
+		"host":         host,
+		"port":         strconv.Itoa(port),
+		"expect":       "SSH-2.0",
+		"read_timeout": "1s",
+	})
+	if err != nil {
+		t.Fatalf("got error %s", err)
+	}
+	if got["res__open"] != "true" || got["res__matched"] != "true" || got["req__protocol"] != "tcp" {
+		t.Errorf("unexpected result %#v", got)
+	}
+}
@@ -0,0 +1,243 @@
+package probe
+
+import (
+	"fmt"
+	"strings"
+)
+
+const templateParams = "params"
+
+// expandTemplates replaces steps with `uses_template` by the named templates.
+// The `with` of such a step is passed to the template as params, and only `{params...}` expressions
+// are evaluated here, so that other expressions are left to be evaluated at runtime.
+func (w *Workflow) expandTemplates() error {
+	for i := range w.Jobs {
+		for j, st := range w.Jobs[i].Steps {
+			if st.UsesTemplate == "" {
+				continue
+			}
+			expanded, err := w.expandTemplate(st, nil)
+			if err != nil {
+				return fmt.Errorf("jobs[%d].steps[%d]: %w", i, j, err)
+			}
+			w.Jobs[i].Steps[j] = expanded
+		}
+	}
+	for name, steps := range w.hooks() {
+		for j, st := range steps {
+			if st.UsesTemplate == "" {
+				continue
+			}
+			expanded, err := w.expandTemplate(st, nil)
+			if err != nil {
+				return fmt.Errorf("%s.steps[%d]: %w", name, j, err)
+			}
+			steps[j] = expanded
+		}
+	}
+
+	return nil
+}
+
+func (w *Workflow) expandTemplate(st *Step, stack []string) (*Step, error) {
+	name := st.UsesTemplate
+	for _, s := range stack {
+		if s == name {
+			return nil, fmt.Errorf("recursive template: %s", strings.Join(append(stack, name), " -> "))
+		}
+	}
+
+	tpl, ok := w.Templates[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown template '%s'", name)
+	}
+
+	env := map[string]any{templateParams: st.With}
+	sub := func(v any) (any, error) {
+		out, err := substituteParams(v, env)
+		if err != nil {
+			return nil, fmt.Errorf("template '%s': %w", name, err)
+		}
+		return out, nil
+	}
+
+	fields := map[string]any{
+		"name": tpl.Name,
+		"uses": tpl.Uses,
+		"with": tpl.With,
+		"test": tpl.Test,
+		"echo": tpl.Echo,
+		"vars": tpl.Vars,
+	}
+	subFields, err := sub(fields)
+	if err != nil {
+		return nil, err
+	}
+	f := subFields.(map[string]any)
+
+	expanded := &Step{
+		Name:            fmt.Sprint(f["name"]),
+		Uses:            fmt.Sprint(f["uses"]),
+		Test:            fmt.Sprint(f["test"]),
+		Echo:            fmt.Sprint(f["echo"]),
+		Iter:            tpl.Iter,
+		ContinueOnError: tpl.ContinueOnError,
+		Timeout:         tpl.Timeout,
+		UsesTemplate:    tpl.UsesTemplate,
+		RtThreshold:     tpl.RtThreshold,
+		Retry:           tpl.Retry,
+		RateLimit:       tpl.RateLimit,
+		WaitFor:         tpl.WaitFor,
+
+		ExpectStatus:       tpl.ExpectStatus,
+		ExpectBodyContains: tpl.ExpectBodyContains,
+		ExpectHeader:       tpl.ExpectHeader,
+	}
+	if with, ok := f["with"].(map[string]any); ok {
+		expanded.With = with
+	}
+	if vars, ok := f["vars"].(map[string]any); ok {
+		expanded.Vars = vars
+	}
+
+	// the template uses another template
+	if expanded.UsesTemplate != "" {
+		if expanded, err = w.expandTemplate(expanded, append(stack, name)); err != nil {
+			return nil, err
+		}
+	}
+
+	// fields of the calling step override the template
+	if st.Name != "" {
+		expanded.Name = st.Name
+	}
+	if st.Test != "" {
+		expanded.Test = st.Test
+	}
+	if st.Echo != "" {
+		expanded.Echo = st.Echo
+	}
+	if len(st.Vars) > 0 {
+		expanded.Vars = MergeMaps(expanded.Vars, st.Vars)
+	}
+	if len(st.Iter) > 0 {
+		expanded.Iter = st.Iter
+	}
+	if st.ContinueOnError {
+		expanded.ContinueOnError = true
+	}
+	if st.Timeout > 0 {
+		expanded.Timeout = st.Timeout
+	}
+	if st.RtThreshold > 0 {
+		expanded.RtThreshold = st.RtThreshold
+	}
+	if st.Retry != nil {
+		expanded.Retry = st.Retry
+	}
+	if st.RateLimit > 0 {
+		expanded.RateLimit = st.RateLimit
+	}
+	if st.WaitFor != nil {
+		expanded.WaitFor = st.WaitFor
+	}
+	if st.ExpectStatus != 0 {
+		expanded.ExpectStatus = st.ExpectStatus
+	}
+	if st.ExpectBodyContains != "" {
+		expanded.ExpectBodyContains = st.ExpectBodyContains
+	}
+	if len(st.ExpectHeader) > 0 {
+		header := make(map[string]string, len(expanded.ExpectHeader)+len(st.ExpectHeader))
+		for k, v := range expanded.ExpectHeader {
+			header[k] = v
+		}
+		for k, v := range st.ExpectHeader {
+			header[k] = v
+		}
+		expanded.ExpectHeader = header
+	}
+
+	return expanded, nil
+}
+
+// substituteParams evaluates `{params...}` expressions in strings, maps and slices.
+// A string consisting of only one expression is replaced with the value as is, to keep its type.
+func substituteParams(v any, env map[string]any) (any, error) {
+	switch val := v.(type) {
+	case string:
+		return substituteParamsString(val, env)
+
+	case map[string]any:
+		if val == nil {
+			return val, nil
+		}
+		out := make(map[string]any, len(val))
+		for k, vv := range val {
+			sv, err := substituteParams(vv, env)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = sv
+		}
+		return out, nil
+
+	case []any:
+		out := make([]any, len(val))
+		for i, vv := range val {
+			sv, err := substituteParams(vv, env)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = sv
+		}
+		return out, nil
+
+	default:
+		return v, nil
+	}
+}
+
+func substituteParamsString(s string, env map[string]any) (any, error) {
+	expr := &Expr{}
+	eval := func(match string) (any, bool, error) {
+		code := strings.TrimSpace(match[1 : len(match)-1])
+		if code != templateParams && !strings.HasPrefix(code, templateParams+".") && !strings.HasPrefix(code, templateParams+"[") {
+			return nil, false, nil
+		}
+		out, err := expr.Eval(code, env)
+		if err != nil {
+			return nil, true, err
+		}
+		if out == nil {
+			return nil, true, fmt.Errorf("param is not given: %s", code)
+		}
+		return out, true, nil
+	}
+
+	if loc := templateRegexp.FindStringIndex(s); loc != nil && loc[0] == 0 && loc[1] == len(s) {
+		out, ok, err := eval(s)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return out, nil
+		}
+		return s, nil
+	}
+
+	var evalErr error
+	out := templateRegexp.ReplaceAllStringFunc(s, func(match string) string {
+		out, ok, err := eval(match)
+		if err != nil {
+			evalErr = err
+			return match
+		}
+		if !ok {
+			return match
+		}
+		return fmt.Sprintf("%v", out)
+	})
+
+	return out, evalErr
+}
@@ -0,0 +1,109 @@
+package probe
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExpandTemplates(t *testing.T) {
+	p := &Probe{FilePath: "./testdata/template-workflow.yml"}
+	if err := p.Load(); err != nil {
+		t.Fatalf("probe load error %s", err)
+	}
+
+	steps := p.workflow.Jobs[0].Steps
+	expects := []*Step{
+		{
+			Name: "Login as alice",
+			Uses: "http",
+			With: map[string]any{
+				"post": "/login",
+				"body": map[string]any{"user": "alice", "id": uint64(1), "token": "{vars.token}"},
+			},
+			Test: "res.code == 200",
+		},
+		{
+			Name: "Admin",
+			Uses: "http",
+			With: map[string]any{
+				"post": "/login",
+				"body": map[string]any{"user": "admin", "id": uint64(2), "token": "{vars.token}"},
+			},
+			Test: "res.code == 201",
+		},
+	}
+
+	if !reflect.DeepEqual(steps, expects) {
+		t.Errorf("\nExpected:\n%#v\n%#v\nGot:\n%#v\n%#v", expects[0], expects[1], steps[0], steps[1])
+	}
+}
+
+func TestExpandTemplatesExpect(t *testing.T) {
+	wf := &Workflow{
+		Templates: map[string]*Step{
+			"create": {
+				Uses:         "http",
+				With:         map[string]any{"post": "/items"},
+				ExpectStatus: 201,
+				ExpectHeader: map[string]string{"content-type": "application/json", "cache-control": "no-store"},
+			},
+		},
+		Jobs: []Job{{Steps: []*Step{{
+			UsesTemplate:       "create",
+			ExpectBodyContains: "created",
+			ExpectHeader:       map[string]string{"cache-control": "no-cache"},
+		}}}},
+	}
+	if err := wf.expandTemplates(); err != nil {
+		t.Fatalf("expandTemplates error %s", err)
+	}
+
+	got := wf.Jobs[0].Steps[0]
+	header := map[string]string{"content-type": "application/json", "cache-control": "no-cache"}
+	if got.ExpectStatus != 201 || got.ExpectBodyContains != "created" || !reflect.DeepEqual(got.ExpectHeader, header) {
+		t.Errorf("expected the expect shortcuts of the template and the step, got status %d, body %q, header %v",
+			got.ExpectStatus, got.ExpectBodyContains, got.ExpectHeader)
+	}
+}
+
+func TestExpandTemplatesErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		templates map[string]*Step
+		step      *Step
+		err       string
+	}{
+		{
+			name: "unknown template",
+			step: &Step{UsesTemplate: "nothing"},
+			err:  "jobs[0].steps[0]: unknown template 'nothing'",
+		},
+		{
+			name: "recursive template",
+			templates: map[string]*Step{
+				"a": {UsesTemplate: "b"},
+				"b": {UsesTemplate: "a"},
+			},
+			step: &Step{UsesTemplate: "a"},
+			err:  "jobs[0].steps[0]: recursive template: a -> b -> a",
+		},
+		{
+			name: "missing param",
+			templates: map[string]*Step{
+				"a": {Uses: "http", With: map[string]any{"url": "{params.url}"}},
+			},
+			step: &Step{UsesTemplate: "a"},
+			err:  "jobs[0].steps[0]: template 'a': param is not given: params.url",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wf := &Workflow{Templates: tt.templates, Jobs: []Job{{Steps: []*Step{tt.step}}}}
+			err := wf.expandTemplates()
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("expected error %s, got %v", tt.err, err)
+			}
+		})
+	}
+}
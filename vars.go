@@ -0,0 +1,172 @@
+package probe
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/goccy/go-yaml"
+)
+
+// LoadVarsFile loads variables from a yaml, json or dotenv file.
+// The format is chosen by the file extension, and dotenv is used for unknown extensions.
+func LoadVarsFile(path string) (map[string]any, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("vars file: %w", err)
+	}
+
+	switch filepath.Ext(path) {
+	case ".yml", ".yaml", ".json":
+		vars := map[string]any{}
+		if err := yaml.Unmarshal(b, &vars); err != nil {
+			return nil, fmt.Errorf("vars file %s is malformed: %w", path, err)
+		}
+		return vars, nil
+	default:
+		env, err := parseDotenv(b)
+		if err != nil {
+			return nil, fmt.Errorf("vars file %s is malformed: %w", path, err)
+		}
+		return StrmapToAnymap(env), nil
+	}
+}
+
+// LoadEnvFile loads environment variables from a dotenv file.
+func LoadEnvFile(path string) (map[string]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("env file: %w", err)
+	}
+	env, err := parseDotenv(b)
+	if err != nil {
+		return nil, fmt.Errorf("env file %s is malformed: %w", path, err)
+	}
+	return env, nil
+}
+
+var (
+	// exportedEnv is the variables set to the process env by export, with the values set
+	exportedEnv   = map[string]string{}
+	exportedEnvMu sync.Mutex
+)
+
+// loadEnvFiles merges env files into the env of the workflow. Later files override earlier ones,
+// and variables set in the process are not overridden. The process env is changed only with export.
+// Variables exported by the previous load are removed first, so that reruns see the edited files.
+func (w *Workflow) loadEnvFiles(paths []string, export bool) error {
+	exportedEnvMu.Lock()
+	defer exportedEnvMu.Unlock()
+
+	env := w.Env()
+	for k, v := range exportedEnv {
+		// the variable changed by others is not ours anymore
+		if cur, set := os.LookupEnv(k); set && cur == v {
+			if err := os.Unsetenv(k); err != nil {
+				return err
+			}
+			if env[k] == v {
+				delete(env, k)
+			}
+		}
+		delete(exportedEnv, k)
+	}
+
+	for _, path := range paths {
+		fileEnv, err := LoadEnvFile(path)
+		if err != nil {
+			return err
+		}
+		for k, v := range fileEnv {
+			if _, set := os.LookupEnv(k); set {
+				continue
+			}
+			env[k] = v
+		}
+	}
+	if !export {
+		return nil
+	}
+	for k, v := range env {
+		if _, set := os.LookupEnv(k); !set {
+			if err := os.Setenv(k, v); err != nil {
+				return err
+			}
+			exportedEnv[k] = v
+		}
+	}
+	return nil
+}
+
+// parseDotenv parses `KEY=VALUE` lines, skipping blank lines and comments.
+// Keys may have the `export` prefix. Values in double quotes are unescaped, values in single quotes
+// are taken as they are, and comments after unquoted values are removed.
+func parseDotenv(b []byte) (map[string]string, error) {
+	env := make(map[string]string)
+	sc := bufio.NewScanner(bytes.NewReader(b))
+
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, "export "))
+		parts := strings.SplitN(line, "=", 2)
+		key := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || key == "" {
+			return nil, fmt.Errorf("line %d: expected KEY=VALUE", n)
+		}
+		v, err := parseDotenvValue(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		env[key] = v
+	}
+
+	return env, sc.Err()
+}
+
+func parseDotenvValue(v string) (string, error) {
+	if v == "" || (v[0] != '"' && v[0] != '\'') {
+		for i := 1; i < len(v); i++ {
+			if v[i] == '#' && (v[i-1] == ' ' || v[i-1] == '\t') {
+				v = v[:i]
+				break
+			}
+		}
+		return strings.TrimSpace(v), nil
+	}
+
+	quote := v[0]
+	var b strings.Builder
+	for i := 1; i < len(v); i++ {
+		c := v[i]
+		switch {
+		case c == quote:
+			if rest := strings.TrimSpace(v[i+1:]); rest != "" && !strings.HasPrefix(rest, "#") {
+				return "", fmt.Errorf("unexpected %q after the quoted value", rest)
+			}
+			return b.String(), nil
+		case c == '\\' && quote == '"' && i+1 < len(v):
+			i++
+			switch v[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			default:
+				b.WriteByte(v[i])
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", errors.New("unterminated quoted value")
+}
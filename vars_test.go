@@ -0,0 +1,181 @@
+package probe
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEvalVarsWithVarsFiles(t *testing.T) {
+	wf := &Workflow{
+		Name: "Test",
+		Vars: map[string]any{
+			"user": map[string]any{"role": "guest"},
+		},
+		VarsFiles: []string{"./testdata/vars/base.yml", "./testdata/vars/override.env"},
+		env:       map[string]string{"TOKEN": "secrets"},
+	}
+
+	got, err := wf.evalVars("")
+	if err != nil {
+		t.Fatalf("evalVars error %s", err)
+	}
+
+	expected := map[string]any{
+		"host":  "http://localhost:3000",
+		"token": "secrets",
+		"user": map[string]any{
+			"name": "alice",
+			"role": "guest",
+		},
+	}
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("\nExpected:\n%#v\nGot:\n%#v", expected, got)
+	}
+}
+
+func TestEvalVarsWithProfile(t *testing.T) {
+	wf := &Workflow{
+		Name: "Test",
+		Vars: map[string]any{
+			"host": "http://localhost:3000",
+			"user": map[string]any{"name": "alice", "role": "guest"},
+		},
+		Profiles: map[string]map[string]any{
+			"staging": {"host": "https://staging.example.com", "user": map[string]any{"role": "admin"}},
+			"prod":    {"host": "https://example.com", "token": "{TOKEN}"},
+		},
+		env: map[string]string{"TOKEN": "secrets"},
+	}
+
+	got, err := wf.evalVars("staging")
+	if err != nil {
+		t.Fatalf("evalVars error %s", err)
+	}
+	expected := map[string]any{
+		"host": "https://staging.example.com",
+		"user": map[string]any{"name": "alice", "role": "admin"},
+	}
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("\nExpected:\n%#v\nGot:\n%#v", expected, got)
+	}
+
+	got, err = wf.evalVars("prod")
+	if err != nil || got["token"] != "secrets" {
+		t.Errorf("expected expressions in the profile to be evaluated, got %#v (%v)", got, err)
+	}
+
+	_, err = wf.evalVars("dev")
+	if err == nil || err.Error() != "profile 'dev' is not found in: prod, staging" {
+		t.Errorf("expected the unknown profile error, got %v", err)
+	}
+}
+
+func TestLoadVarsFileErrors(t *testing.T) {
+	if _, err := LoadVarsFile("./testdata/vars/missing.yml"); err == nil || !strings.Contains(err.Error(), "no such file") {
+		t.Errorf("expected missing file error, got %v", err)
+	}
+
+	if _, err := parseDotenv([]byte("FOO=bar\nBAZ\n")); err == nil || err.Error() != "line 2: expected KEY=VALUE" {
+		t.Errorf("expected malformed error, got %v", err)
+	}
+
+	if _, err := parseDotenv([]byte("FOO=\"bar\n")); err == nil || err.Error() != "line 1: unterminated quoted value" {
+		t.Errorf("expected unterminated error, got %v", err)
+	}
+}
+
+func TestLoadEnvFile(t *testing.T) {
+	got, err := LoadEnvFile("./testdata/vars/local.env")
+	if err != nil {
+		t.Fatalf("LoadEnvFile error %s", err)
+	}
+	expected := map[string]string{
+		"API_TOKEN": "s3cr3t # not a comment",
+		"API_HOST":  "http://localhost:3000",
+		"GREETING":  "hello\nworld",
+		"RAW":       `single \n quoted`,
+		"EMPTY":     "",
+	}
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("\nExpected:\n%#v\nGot:\n%#v", expected, got)
+	}
+}
+
+func TestLoadEnvFiles(t *testing.T) {
+	t.Setenv("API_HOST", "https://example.com")
+	os.Unsetenv("API_TOKEN")
+
+	wf := &Workflow{
+		Name: "Test",
+		Vars: map[string]any{"token": "{API_TOKEN}", "host": "{API_HOST}"},
+		env:  map[string]string{"API_HOST": "https://example.com"},
+	}
+	if err := wf.loadEnvFiles([]string{"./testdata/vars/local.env"}, false); err != nil {
+		t.Fatalf("loadEnvFiles error %s", err)
+	}
+	got, err := wf.evalVars("")
+	if err != nil {
+		t.Fatalf("evalVars error %s", err)
+	}
+	// the process env is not overridden
+	expected := map[string]any{"token": "s3cr3t # not a comment", "host": "https://example.com"}
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("\nExpected:\n%#v\nGot:\n%#v", expected, got)
+	}
+	if _, set := os.LookupEnv("API_TOKEN"); set {
+		t.Error("expected the process env not to be changed without export")
+	}
+
+	t.Cleanup(func() { os.Unsetenv("API_TOKEN") })
+	if err := wf.loadEnvFiles([]string{"./testdata/vars/local.env"}, true); err != nil {
+		t.Fatalf("loadEnvFiles error %s", err)
+	}
+	if v := os.Getenv("API_TOKEN"); v != "s3cr3t # not a comment" {
+		t.Errorf("expected the process env to be set with export, got %q", v)
+	}
+
+	if err := wf.loadEnvFiles([]string{"./testdata/vars/missing.env"}, false); err == nil || !strings.Contains(err.Error(), "no such file") {
+		t.Errorf("expected missing file error, got %v", err)
+	}
+}
+
+func TestLoadEnvFilesReload(t *testing.T) {
+	os.Unsetenv("PROBE_RELOAD_TOKEN")
+	os.Unsetenv("PROBE_RELOAD_REMOVED")
+	t.Cleanup(func() {
+		os.Unsetenv("PROBE_RELOAD_TOKEN")
+		os.Unsetenv("PROBE_RELOAD_REMOVED")
+	})
+
+	path := filepath.Join(t.TempDir(), ".env")
+	load := func(content string) *Workflow {
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		// a rerun of watch and schedule loads a new workflow with the process env
+		wf := &Workflow{Name: "Test"}
+		if err := wf.loadEnvFiles([]string{path}, true); err != nil {
+			t.Fatalf("loadEnvFiles error %s", err)
+		}
+		return wf
+	}
+
+	load("PROBE_RELOAD_TOKEN=old\nPROBE_RELOAD_REMOVED=yes\n")
+	wf := load("PROBE_RELOAD_TOKEN=new\n")
+
+	if v := os.Getenv("PROBE_RELOAD_TOKEN"); v != "new" {
+		t.Errorf("expected the edited value to be exported, got %q", v)
+	}
+	if v := wf.Env()["PROBE_RELOAD_TOKEN"]; v != "new" {
+		t.Errorf("expected the edited value in the env, got %q", v)
+	}
+	if _, set := os.LookupEnv("PROBE_RELOAD_REMOVED"); set {
+		t.Error("expected the removed variable to be unset")
+	}
+	if _, ok := wf.Env()["PROBE_RELOAD_REMOVED"]; ok {
+		t.Error("expected the removed variable not to be in the env")
+	}
+}
@@ -0,0 +1,204 @@
+package websocket
+
+import (
+	"errors"
+	hp "net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	ws "github.com/gorilla/websocket"
+	"github.com/linyows/probe"
+)
+
+type Req struct {
+	URL            string            `map:"url" validate:"required"`
+	Header         map[string]string `map:"headers"`
+	Messages       map[string]string `map:"messages"`
+	ExpectMessages int               `map:"expect_messages"`
+	Ping           bool              `map:"ping"`
+	Timeout        time.Duration     `map:"timeout"`
+	cb             *Callback
+}
+
+type Res struct {
+	Status      string            `map:"status"`
+	Code        int               `map:"code"`
+	Header      map[string]string `map:"headers"`
+	Messages    []string          `map:"messages"`
+	Pong        bool              `map:"pong"`
+	ConnectTime time.Duration     `map:"connect_time"`
+	RT          time.Duration     `map:"rt"`
+}
+
+type Result struct {
+	Req Req `map:"req"`
+	Res Res `map:"res"`
+}
+
+func NewReq() *Req {
+	return &Req{
+		Header: map[string]string{
+			"User-Agent": "probe-websocket/1.0.0",
+		},
+		Messages: map[string]string{},
+		Timeout:  10 * time.Second,
+	}
+}
+
+// Do connects to the url, sends messages in index order, and reads the expected number of messages.
+// When ping is enabled, a ping is sent and the result reports whether the pong arrived before the timeout.
+func (r *Req) Do() (*Result, error) {
+	if r.URL == "" {
+		return nil, errors.New("Req.URL is required")
+	}
+
+	header := hp.Header{}
+	for k, v := range r.Header {
+		header.Set(probe.TitleCase(k, "-"), v)
+	}
+
+	// callback
+	if r.cb != nil && r.cb.before != nil {
+		r.cb.before(r.URL, header)
+	}
+
+	start := time.Now()
+	deadline := start.Add(r.Timeout)
+	dialer := ws.Dialer{HandshakeTimeout: r.Timeout}
+
+	conn, res, err := dialer.Dial(r.URL, header)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	result := &Result{
+		Req: *r,
+		Res: Res{
+			Status:      res.Status,
+			Code:        res.StatusCode,
+			Header:      map[string]string{},
+			Messages:    []string{},
+			ConnectTime: time.Since(start),
+		},
+	}
+	for k, v := range res.Header {
+		result.Res.Header[k] = v[0]
+	}
+
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	pong := false
+	if r.Ping {
+		conn.SetPongHandler(func(string) error {
+			pong = true
+			return nil
+		})
+		if err := conn.WriteControl(ws.PingMessage, []byte("probe"), deadline); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, msg := range r.sortedMessages() {
+		if err := conn.WriteMessage(ws.TextMessage, []byte(msg)); err != nil {
+			return nil, err
+		}
+	}
+
+	for len(result.Res.Messages) < r.ExpectMessages {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return nil, err
+		}
+		result.Res.Messages = append(result.Res.Messages, string(data))
+	}
+
+	// the pong handler is called while reading, so keep reading until the pong arrives or the timeout
+	for r.Ping && !pong {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			break
+		}
+	}
+	result.Res.Pong = pong
+
+	_ = conn.WriteControl(ws.CloseMessage, ws.FormatCloseMessage(ws.CloseNormalClosure, ""), time.Now().Add(time.Second))
+	result.Res.RT = time.Since(start)
+
+	// callback
+	if r.cb != nil && r.cb.after != nil {
+		r.cb.after(&result.Res)
+	}
+
+	return result, nil
+}
+
+// sortedMessages returns messages ordered by the numeric index of `messages__<index>`.
+func (r *Req) sortedMessages() []string {
+	keys := make([]string, 0, len(r.Messages))
+	for k := range r.Messages {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, errA := strconv.Atoi(keys[i])
+		b, errB := strconv.Atoi(keys[j])
+		if errA != nil || errB != nil {
+			return keys[i] < keys[j]
+		}
+		return a < b
+	})
+
+	msgs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		msgs = append(msgs, r.Messages[k])
+	}
+	return msgs
+}
+
+type Option func(*Callback)
+
+type Callback struct {
+	before func(url string, header hp.Header)
+	after  func(res *Res)
+}
+
+func Request(data map[string]string, opts ...Option) (map[string]string, error) {
+	m := probe.UnflattenInterface(data)
+	r := NewReq()
+
+	cb := &Callback{}
+	for _, opt := range opts {
+		opt(cb)
+	}
+	r.cb = cb
+
+	if err := probe.MapToStructByTags(m, r); err != nil {
+		return map[string]string{}, err
+	}
+
+	ret, err := r.Do()
+	if err != nil {
+		return map[string]string{}, err
+	}
+
+	mapRet, err := probe.StructToMapByTags(ret)
+	if err != nil {
+		return map[string]string{}, err
+	}
+
+	return probe.FlattenInterface(mapRet), nil
+}
+
+func WithBefore(f func(url string, header hp.Header)) Option {
+	return func(c *Callback) {
+		c.before = f
+	}
+}
+
+func WithAfter(f func(res *Res)) Option {
+	return func(c *Callback) {
+		c.after = f
+	}
+}
@@ -0,0 +1,73 @@
+package websocket
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/linyows/probe/websocket/testserver"
+)
+
+func TestDo(t *testing.T) {
+	s, url := testserver.New("Bearer secret")
+	defer s.Close()
+
+	req := NewReq()
+	req.URL = url
+	req.Header["authorization"] = "Bearer secret"
+	req.Messages = map[string]string{"0": "hello", "1": "upper:world", "10": "last", "2": "again"}
+	req.ExpectMessages = 4
+	req.Ping = true
+
+	got, err := req.Do()
+	if err != nil {
+		t.Fatalf("got error %s", err)
+	}
+
+	expects := []string{"hello", "WORLD", "again", "last"}
+	if !reflect.DeepEqual(got.Res.Messages, expects) {
+		t.Errorf("\nExpected:\n%#v\nGot:\n%#v", expects, got.Res.Messages)
+	}
+	if got.Res.Code != 101 {
+		t.Errorf("expected code 101, got %d", got.Res.Code)
+	}
+	if !got.Res.Pong {
+		t.Error("expected pong")
+	}
+	if got.Res.Header["X-Probe-Test"] != "websocket" {
+		t.Errorf("unexpected headers %#v", got.Res.Header)
+	}
+	if got.Res.ConnectTime <= 0 || got.Res.RT < got.Res.ConnectTime {
+		t.Errorf("unexpected timing connect: %s, rt: %s", got.Res.ConnectTime, got.Res.RT)
+	}
+}
+
+func TestDoUnauthorized(t *testing.T) {
+	s, url := testserver.New("Bearer secret")
+	defer s.Close()
+
+	req := NewReq()
+	req.URL = url
+
+	if _, err := req.Do(); err == nil {
+		t.Error("expected handshake error")
+	}
+}
+
+func TestRequest(t *testing.T) {
+	s, url := testserver.New("")
+	defer s.Close()
+
+	got, err := Request(map[string]string{
+		"url":             url,
+		"messages__0":     "ping?",
+		"expect_messages": "1",
+		"timeout":         "2s",
+	})
+	if err != nil {
+		t.Fatalf("got error %s", err)
+	}
+
+	if got["res__messages__0"] != "ping?" || got["res__code"] != "101" || got["req__url"] != url {
+		t.Errorf("unexpected result %#v", got)
+	}
+}
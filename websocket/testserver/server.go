@@ -0,0 +1,48 @@
+package testserver
+
+import (
+	hp "net/http"
+	"net/http/httptest"
+	"strings"
+
+	ws "github.com/gorilla/websocket"
+)
+
+var upgrader = ws.Upgrader{}
+
+// Handler echoes text messages. A message "upper:<text>" is echoed in upper case,
+// and the Authorization header is required when requireAuth is set.
+func Handler(requireAuth string) hp.Handler {
+	return hp.HandlerFunc(func(w hp.ResponseWriter, r *hp.Request) {
+		if requireAuth != "" && r.Header.Get("Authorization") != requireAuth {
+			hp.Error(w, "unauthorized", hp.StatusUnauthorized)
+			return
+		}
+
+		conn, err := upgrader.Upgrade(w, r, hp.Header{"X-Probe-Test": []string{"websocket"}})
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		for {
+			mt, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			msg := string(data)
+			if after, ok := strings.CutPrefix(msg, "upper:"); ok {
+				msg = strings.ToUpper(after)
+			}
+			if err := conn.WriteMessage(mt, []byte(msg)); err != nil {
+				return
+			}
+		}
+	})
+}
+
+// New starts an echo server, and returns it with the websocket url.
+func New(requireAuth string) (*httptest.Server, string) {
+	s := httptest.NewServer(Handler(requireAuth))
+	return s, "ws" + strings.TrimPrefix(s.URL, "http")
+}
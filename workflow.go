@@ -1,19 +1,67 @@
 package probe
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/fatih/color"
+	"github.com/mattn/go-isatty"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	"golang.org/x/time/rate"
 )
 
+const (
+	BeforeAllJob = "before_all"
+	AfterAllJob  = "after_all"
+)
+
+// actionRunner runs an action, like RunActions
+type actionRunner func(ctx context.Context, name string, args []string, with map[string]any, verbose bool) (map[string]any, error)
+
+// newActionRunner returns the runner of actions for a workflow run, and the function to clean it up.
+// It is a variable so that tests can replace the plugin execution.
+var newActionRunner = func(log io.Writer) (actionRunner, func()) {
+	clients := NewActionClients(log)
+	return clients.Run, clients.Close
+}
+
+// envRefRegexp finds references to environment variables like `env.TOKEN` in expressions
+var envRefRegexp = regexp.MustCompile(`\benv\.([A-Za-z_][A-Za-z0-9_]*)`)
+
+// VarsFiles are yaml, json or dotenv files merged under vars. Later files override earlier ones,
+// and inline vars override all files. Templates are reusable steps used by `uses_template` in steps.
+// Secrets are key patterns added to the defaults, of which values are masked in the output.
+// BeforeAll runs before any job, and jobs run only when it passes. AfterAll runs after all jobs, even when they failed.
+// Profiles are vars by environment like staging, of which the selected one overrides vars.
+// Notify posts a summary to a webhook when the workflow finishes. Otel exports traces of the run.
 type Workflow struct {
-	Name       string         `yaml:"name",validate:"required"`
-	Jobs       []Job          `yaml:"jobs",validate:"required"`
-	Vars       map[string]any `yaml:"vars"`
+	Name       string                    `yaml:"name",validate:"required"`
+	Jobs       []Job                     `yaml:"jobs",validate:"required"`
+	Vars       map[string]any            `yaml:"vars"`
+	Profiles   map[string]map[string]any `yaml:"profiles,omitempty"`
+	VarsFiles  []string                  `yaml:"vars_files,omitempty"`
+	Templates  map[string]*Step          `yaml:"templates,omitempty"`
+	Secrets    []string                  `yaml:"secrets,omitempty"`
+	Notify     *Notify                   `yaml:"notify,omitempty"`
+	Otel       *Otel                     `yaml:"otel,omitempty"`
+	BeforeAll  []*Step                   `yaml:"before_all,omitempty"`
+	AfterAll   []*Step                   `yaml:"after_all,omitempty"`
 	exitStatus int
 	env        map[string]string
+	result     *Result
 }
 
 func (w *Workflow) SetExitStatus(isErr bool) {
@@ -23,37 +71,440 @@ func (w *Workflow) SetExitStatus(isErr bool) {
 }
 
 func (w *Workflow) Start(c Config) error {
-	vars, err := w.evalVars()
+	if c.Log == nil {
+		c.Log = os.Stdout
+	}
+	interactive := isTerminal(c.Log)
+	if !useColor(c) {
+		color.NoColor = true
+	} else if c.ForceColor {
+		color.NoColor = false
+	}
+	if !IsOutputFormat(c.OutputFormat) {
+		return &ConfigError{Err: fmt.Errorf("unknown output format '%s'", c.OutputFormat)}
+	}
+	if !IsLogFormat(c.LogFormat) {
+		return &ConfigError{Err: fmt.Errorf("unknown log format '%s'", c.LogFormat)}
+	}
+
+	if err := w.loadEnvFiles(c.EnvFiles, c.ExportEnv); err != nil {
+		return &ConfigError{Err: err}
+	}
+	vars, err := w.evalVars(c.Profile)
 	if err != nil {
-		return err
+		return &ConfigError{Err: err}
+	}
+	if err := w.checkEnv(); err != nil {
+		return &ConfigError{Err: err}
+	}
+
+	for i, job := range w.Jobs {
+		if job.Repeat == nil {
+			continue
+		}
+		if job.Repeat.Until != "" && job.Repeat.maxCount() <= 0 {
+			return &ConfigError{Err: fmt.Errorf("jobs[%d]: repeat with until requires max_count", i)}
+		}
+		if job.Repeat.Jitter < 0 || job.Repeat.Jitter > 100 {
+			return &ConfigError{Err: fmt.Errorf("jobs[%d]: repeat jitter must be between 0 and 100", i)}
+		}
+	}
+
+	if c.DryRun {
+		if err := w.DryRun(c, vars); err != nil {
+			return &ConfigError{Err: err}
+		}
+		return nil
 	}
 
+	// Secret values never appear in the console output and the report
+	secrets := w.secretValues(vars)
+	c.Log = newMaskWriter(c.Log, secrets)
+
+	if c.DumpDir != "" {
+		if err := os.MkdirAll(c.DumpDir, 0o755); err != nil {
+			return &ConfigError{Err: err}
+		}
+	}
+
+	// The summary file is created before the run, so that a wrong path does not waste the run
+	var summary *os.File
+	if c.SummaryPath != "" {
+		f, err := CreateSummaryFile(c.SummaryPath)
+		if err != nil {
+			return &ConfigError{Err: err}
+		}
+		defer f.Close()
+		summary = f
+	}
+
+	// Jobs passed in the previous run are skipped
+	var rerun map[string]bool
+	if c.RerunFailed != "" {
+		prev, err := ReadResultFile(c.RerunFailed)
+		if err != nil {
+			return &ConfigError{Err: fmt.Errorf("rerun-failed: %w", err)}
+		}
+		rerun = prev.FailedJobs()
+	}
+
+	// The console output is replaced with the report when an output format is given,
+	// and with json lines of events when the log format is json
+	out := c.Log
+	var events *eventLog
+	var listeners eventListeners
+	if c.LogFormat == LogFormatJSON {
+		events = newEventLog(c.Log, w.Name)
+		listeners = append(listeners, events)
+	}
+	if c.OutputFormat != "" || events != nil {
+		c.Log = io.Discard
+	}
+	// Errors after the run keep the report and the event log parsable
+	errOut := out
+	if c.OutputFormat != "" || events != nil {
+		errOut = newMaskWriter(os.Stderr, secrets)
+	}
+
+	// The progress is shown only on the terminal, below the console output
+	var prog *progress
+	if interactive && c.OutputFormat == "" && events == nil && !color.NoColor {
+		prog = newProgress(c.Log, w.runCount())
+		c.Log = prog
+		listeners = append(listeners, prog)
+	}
+
+	// Plugins are started once by each action, and shared among steps in the run.
+	// Logs of plugins, which have params of actions in verbose, are masked as well.
+	runAction, cleanup := newActionRunner(errOut)
+	defer cleanup()
+
+	w.result = &Result{Name: w.Name, StartTime: time.Now()}
 	ctx := w.newJobContext(c, vars)
+	ctx.runAction = runAction
+	ctx.secrets = secrets
+	ctx.events = listeners
+	ctx.rerun = rerun
+
+	tracer, shutdown, err := w.newTracer(ctx)
+	if err != nil {
+		return &ConfigError{Err: fmt.Errorf("otel: %w", err)}
+	}
+	defer func() {
+		if err := shutdown(); err != nil {
+			fmt.Fprintf(errOut, "%s %s\n", color.RedString("Otel Error:"), err)
+		}
+	}()
+	ctx.tracer = tracer
+	// Jobs are aborted by canceling the context of the run, or by the deadline of the timeout
+	runCtx, abort := context.WithCancel(context.Background())
+	defer abort()
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(runCtx, c.Timeout)
+		defer cancel()
+	}
+	var span trace.Span
+	ctx.spanCtx, span = tracer.Start(runCtx, w.Name)
+
+	listeners.emit(Event{Event: EventWorkflowStart})
+	if prog != nil {
+		prog.start()
+	}
 	var wg sync.WaitGroup
 
-	for _, job := range w.Jobs {
-		// No repeat
-		if job.Repeat == nil {
-			wg.Add(1)
-			go func() {
-				defer wg.Done()
-				w.SetExitStatus(job.Start(ctx))
-			}()
-			continue
+	// Jobs beyond the max concurrency wait until a running job finishes
+	var sem chan struct{}
+	if c.MaxConcurrency > 0 {
+		sem = make(chan struct{}, c.MaxConcurrency)
+	}
+	// With fail-fast, a failed job aborts the others
+	failFast := func(failed bool) {
+		if failed && c.FailFast {
+			abort()
+		}
+	}
+	run := func(job *Job, ctx JobContext) bool {
+		if sem != nil {
+			sem <- struct{}{}
+			defer func() { <-sem }()
+		}
+		failed := job.Start(ctx)
+		// before the waiting job takes the slot, but repeats with until fail after the last run
+		if job.Repeat == nil || job.Repeat.Until == "" {
+			failFast(failed)
+		}
+		return failed
+	}
+	start := func(f func() bool) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			failed := f()
+			w.SetExitStatus(failed)
+			failFast(failed)
+		}()
+	}
+
+	jobs := w.Jobs
+	if len(w.BeforeAll) > 0 {
+		hook := w.hookJob(BeforeAllJob, w.BeforeAll)
+		failed := run(hook, ctx)
+		w.SetExitStatus(failed)
+		if failed {
+			fmt.Fprintf(ctx.Log, "%s\n", color.HiBlackString("skipped: jobs, because %s failed", BeforeAllJob))
+			jobs = nil
 		}
+	}
+
+	for i, job := range jobs {
+		job.idx = i
+		switch {
+		// No repeat
+		case job.Repeat == nil:
+			start(func() bool { return run(&job, ctx) })
+
+		// Repeat one after another until the condition passes
+		case job.Repeat.Until != "":
+			start(func() bool { return w.repeatUntil(job, ctx, run) })
 
 		// Repeat
-		for i := 0; i < job.Repeat.Count; i++ {
-			wg.Add(1)
-			go func() {
-				defer wg.Done()
-				w.SetExitStatus(job.Start(ctx))
-			}()
-			time.Sleep(time.Duration(job.Repeat.Interval) * time.Second)
+		default:
+			interval := job.Repeat.intervalFunc()
+			for i := 0; i < job.Repeat.Count && runCtx.Err() == nil; i++ {
+				j := job
+				j.Steps = job.repeatSteps()
+				start(func() bool { return run(&j, ctx) })
+				sleepContext(runCtx, interval())
+			}
 		}
 	}
 
 	wg.Wait()
+	timedOut := errors.Is(runCtx.Err(), context.DeadlineExceeded)
+	if timedOut {
+		fmt.Fprintf(ctx.Log, "%s\n", color.RedString("timeout: the run exceeded %s", c.Timeout))
+	}
+
+	// after_all is not aborted by fail-fast and the timeout
+	if len(w.AfterAll) > 0 {
+		hook := w.hookJob(AfterAllJob, w.AfterAll)
+		hookCtx := ctx
+		hookCtx.spanCtx = context.WithoutCancel(ctx.spanCtx)
+		w.SetExitStatus(hook.Start(hookCtx))
+	}
+
+	if prog != nil {
+		prog.stop()
+	}
+	w.result.EndTime = time.Now()
+	if status := w.result.ExitStatus(); status > w.exitStatus {
+		w.exitStatus = status
+	}
+	if timedOut {
+		w.exitStatus = ExitTimeout
+	}
+	status := StatusSuccess
+	if w.exitStatus != ExitSuccess {
+		status = StatusFailure
+	}
+	listeners.emit(Event{Event: EventWorkflowEnd, Status: status, DurationMs: durationMs(w.result.Duration())})
+	endSpan(span, status, "")
+
+	// A failure of the summary is reported after the results
+	var summaryErr error
+	if summary != nil {
+		if err := w.result.WriteSummaryFile(summary); err != nil {
+			summaryErr = fmt.Errorf("summary: %w", err)
+		}
+	}
+
+	if c.Compact && c.OutputFormat == "" && events == nil {
+		if err := w.result.WriteFooter(out); err != nil {
+			return errors.Join(err, summaryErr)
+		}
+	}
+
+	if c.Timeline && c.OutputFormat == "" && events == nil {
+		if err := w.result.WriteTimeline(out); err != nil {
+			return errors.Join(err, summaryErr)
+		}
+	}
+
+	err = w.result.Write(out, c.OutputFormat)
+	if w.Notify != nil {
+		w.notify(c, errOut, vars, secrets)
+	}
+
+	return errors.Join(err, summaryErr)
+}
+
+// hookJob returns the job running the steps of before_all or after_all.
+func (w *Workflow) hookJob(name string, steps []*Step) *Job {
+	return &Job{Name: name, Steps: steps, idx: -1}
+}
+
+// hooks returns steps of before_all and after_all by the name.
+// checkEnv returns an error when params of steps refer to environment variables that are not set,
+// so that the run does not start with the missing configuration.
+func (w *Workflow) checkEnv() error {
+	env := StrmapToAnymap(w.Env())
+	check := func(path string, steps []*Step) error {
+		for j, st := range steps {
+			if err := checkEnv(st.With, env); err != nil {
+				return fmt.Errorf("%s.steps[%d]: %w", path, j, err)
+			}
+		}
+		return nil
+	}
+
+	if err := check(BeforeAllJob, w.BeforeAll); err != nil {
+		return err
+	}
+	for i, job := range w.Jobs {
+		if err := check(fmt.Sprintf("jobs[%d]", i), job.Steps); err != nil {
+			return err
+		}
+	}
+	return check(AfterAllJob, w.AfterAll)
+}
+
+func (w *Workflow) hooks() map[string][]*Step {
+	return map[string][]*Step{BeforeAllJob: w.BeforeAll, AfterAllJob: w.AfterAll}
+}
+
+// notify sends the result to the webhook. Delivery failures are logged and never fail the workflow.
+func (w *Workflow) notify(c Config, out io.Writer, vars map[string]any, secrets []string) {
+	expr := &Expr{}
+	url, err := expr.EvalTemplate(w.Notify.WebhookURL, w.newJobContext(c, vars))
+	if err == nil {
+		var body []byte
+		body, err = w.Notify.payload(newNotifyContext(w.result, vars), secrets)
+		if err == nil {
+			err = w.Notify.Send(url, body)
+		}
+	}
+	if err != nil {
+		// the webhook url is a credential itself
+		if url != "" {
+			out = newMaskWriter(out, append(secrets, url))
+		}
+		fmt.Fprintf(out, "%s %s\n", color.RedString("Notify Error:"), err)
+	}
+}
+
+// repeatUntil runs the job one after another until the until expression passes, or the runs reach max_count.
+// Only the last run is recorded, so that runs before the condition holds do not fail the workflow.
+func (w *Workflow) repeatUntil(job Job, ctx JobContext, run func(*Job, JobContext) bool) bool {
+	results := ctx.Results
+	ctx.Results = nil
+	expr := &Expr{}
+	limit := job.Repeat.maxCount()
+	interval := job.Repeat.intervalFunc()
+
+	var failed, passed bool
+	count := 0
+	for count < limit {
+		count++
+		failed = run(&job, ctx)
+		if job.ctx.Result.Skipped || job.ctx.Result.Aborted {
+			break
+		}
+		if passed = job.untilPassed(expr); passed || count == limit {
+			break
+		}
+		sleepContext(ctx.spanCtx, interval())
+	}
+
+	r := job.ctx.Result
+	r.Iterations = count
+	switch {
+	case r.Skipped, r.Aborted:
+	case passed:
+		r.StoppedBy = StoppedByUntil
+		fmt.Fprintf(ctx.Log, "%s\n", color.HiBlackString("repeat: until passed after %d runs", count))
+	default:
+		r.StoppedBy = StoppedByMaxCount
+		r.Failed = true
+		failed = true
+		fmt.Fprintf(ctx.Log, "%s\n", color.RedString("repeat: until was not met in %d runs: %s", count, job.Repeat.Until))
+	}
+	if results != nil {
+		results.Add(r)
+	}
+
+	return failed
+}
+
+// Result returns the result of the last run.
+func (w *Workflow) Result() *Result {
+	return w.result
+}
+
+// DryRun checks that every step uses a known action and prints what would run.
+// No actions are executed.
+func (w *Workflow) DryRun(c Config, vars map[string]any) error {
+	ctx := w.newJobContext(c, vars)
+	expr := &Expr{}
+
+	printSteps := func(path string, steps []*Step) error {
+		for j, st := range steps {
+			if st.Uses == "" {
+				return fmt.Errorf("%s.steps[%d]: uses is required", path, j)
+			}
+			if !IsBuiltinAction(st.Uses) {
+				return fmt.Errorf("%s.steps[%d]: unknown action '%s'", path, j, st.Uses)
+			}
+			if missing := missingActionParams(st.Uses, st.With); len(missing) > 0 {
+				return fmt.Errorf("%s.steps[%d]: action '%s' requires with: %s", path, j, st.Uses, strings.Join(missing, ", "))
+			}
+			stName := st.Name
+			if stName == "" {
+				stName = "Unknown Step"
+			}
+			fmt.Fprintf(c.Log, "%2d. %s (uses: %s)\n", j, stName, st.Uses)
+		}
+		return nil
+	}
+
+	if len(w.BeforeAll) > 0 {
+		fmt.Fprintf(c.Log, "%s\n", BeforeAllJob)
+		if err := printSteps(BeforeAllJob, w.BeforeAll); err != nil {
+			return err
+		}
+	}
+
+	for i, job := range w.Jobs {
+		name := job.Name
+		if name == "" {
+			name = "Unknown Job"
+		}
+		name, err := expr.EvalTemplate(name, ctx)
+		if err != nil {
+			return fmt.Errorf("jobs[%d]: %w", i, err)
+		}
+
+		repeat := ""
+		switch {
+		case job.Repeat == nil:
+		case job.Repeat.Until != "":
+			repeat = fmt.Sprintf(" (repeat: until %s, up to %d times every %ds)", job.Repeat.Until, job.Repeat.maxCount(), job.Repeat.Interval)
+		default:
+			repeat = fmt.Sprintf(" (repeat: %d times every %ds)", job.Repeat.Count, job.Repeat.Interval)
+		}
+		fmt.Fprintf(c.Log, "%s%s\n", name, repeat)
+
+		if err := printSteps(fmt.Sprintf("jobs[%d]", i), job.Steps); err != nil {
+			return err
+		}
+	}
+
+	if len(w.AfterAll) > 0 {
+		fmt.Fprintf(c.Log, "%s\n", AfterAllJob)
+		if err := printSteps(AfterAllJob, w.AfterAll); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
@@ -65,12 +516,31 @@ func (w *Workflow) Env() map[string]string {
 	return w.env
 }
 
-func (w *Workflow) evalVars() (map[string]any, error) {
+func (w *Workflow) evalVars(profile string) (map[string]any, error) {
 	env := StrmapToAnymap(w.Env())
 	vars := make(map[string]any)
 
+	var profileVars map[string]any
+	if profile != "" {
+		var ok bool
+		if profileVars, ok = w.Profiles[profile]; !ok {
+			return vars, fmt.Errorf("profile '%s' is not found in: %s", profile, strings.Join(w.profileNames(), ", "))
+		}
+	}
+
+	src := map[string]any{}
+	for _, path := range w.VarsFiles {
+		fileVars, err := LoadVarsFile(path)
+		if err != nil {
+			return vars, err
+		}
+		src = MergeMaps(src, fileVars)
+	}
+	src = MergeMaps(src, w.Vars)
+	src = MergeMaps(src, profileVars)
+
 	expr := &Expr{}
-	for k, v := range w.Vars {
+	for k, v := range src {
 		if mapV, ok := v.(map[string]any); ok {
 			vars[k] = expr.EvalTemplateMap(mapV, env)
 		} else if strV, ok2 := v.(string); ok2 {
@@ -79,25 +549,88 @@ func (w *Workflow) evalVars() (map[string]any, error) {
 				return vars, err
 			}
 			vars[k] = output
+		} else {
+			vars[k] = v
 		}
 	}
 
 	return vars, nil
 }
 
+// profileNames returns the names of profiles in order.
+func (w *Workflow) profileNames() []string {
+	names := make([]string, 0, len(w.Profiles))
+	for name := range w.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 func (w *Workflow) newJobContext(c Config, vars map[string]any) JobContext {
 	return JobContext{
-		Vars:   vars,
-		Logs:   []map[string]any{},
-		Config: c,
+		Vars:    vars,
+		Env:     StrmapToAnymap(w.Env()),
+		Logs:    []map[string]any{},
+		Config:  c,
+		Results: w.result,
 	}
 }
 
 type JobContext struct {
 	Vars map[string]any   `expr:"vars"`
+	Env  map[string]any   `expr:"env"`
 	Logs []map[string]any `expr:"steps"`
 	Config
-	Failed bool
+	Failed    bool
+	Result    *JobResult
+	Results   *Result
+	runAction actionRunner
+	secrets   []string
+	events    eventListeners
+	jobID     int
+	// rerun is names of jobs to run again, and nil runs all jobs
+	rerun   map[string]bool
+	tracer  trace.Tracer
+	spanCtx context.Context
+}
+
+// aborted reports whether the run is aborted, because a job failed with fail-fast or the run timed out.
+func (j *JobContext) aborted() bool {
+	return j.spanCtx != nil && j.spanCtx.Err() != nil
+}
+
+// abortReason returns why the run is aborted.
+func (j *JobContext) abortReason() string {
+	if errors.Is(j.spanCtx.Err(), context.DeadlineExceeded) {
+		return "the run timed out"
+	}
+	return "another job failed"
+}
+
+// startSpan starts a child span of the current span.
+func (j *JobContext) startSpan(name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	tracer, ctx := j.tracer, j.spanCtx
+	if tracer == nil {
+		tracer = noop.NewTracerProvider().Tracer("")
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// event emits the event of the job, or a step in the job.
+func (j *JobContext) event(e Event) {
+	if len(j.events) == 0 {
+		return
+	}
+	id := j.jobID
+	e.JobID = &id
+	if j.Result != nil {
+		e.Job = j.Result.Name
+	}
+	j.events.emit(e)
 }
 
 func (j *JobContext) SetFailed() {
@@ -106,28 +639,105 @@ func (j *JobContext) SetFailed() {
 
 type StepContext struct {
 	Vars map[string]any   `expr:"vars"`
+	Env  map[string]any   `expr:"env"`
 	Logs []map[string]any `expr:"steps"`
 	Res  map[string]any   `expr:"res"`
 	Req  map[string]any   `expr:"req"`
 }
 
+// Repeat runs the job count times on the interval. When until is given, the job runs one after another
+// until the expression passes against the steps of the last run, up to max_count times, which defaults to count.
+// Jitter randomizes each interval by up to the percentage of it, and seed makes the randomization reproducible.
 type Repeat struct {
-	Count    int `yaml:"count",validate:"required,gte=0,lt=100"`
-	Interval int `yaml:"interval,validate:"gte=0,lt=600"`
+	Count    int    `yaml:"count",validate:"required,gte=0,lt=100"`
+	Interval int    `yaml:"interval,validate:"gte=0,lt=600"`
+	Until    string `yaml:"until,omitempty"`
+	MaxCount int    `yaml:"max_count,omitempty"`
+	Jitter   int    `yaml:"jitter,omitempty"`
+	Seed     int64  `yaml:"seed,omitempty"`
+}
+
+func (r *Repeat) maxCount() int {
+	if r.MaxCount > 0 {
+		return r.MaxCount
+	}
+	return r.Count
+}
+
+// intervalFunc returns a function that returns the next interval, which is in interval ± jitter%.
+func (r *Repeat) intervalFunc() func() time.Duration {
+	base := time.Duration(r.Interval) * time.Second
+	spread := base * time.Duration(r.Jitter) / 100
+	if spread <= 0 {
+		return func() time.Duration { return base }
+	}
+
+	seed := r.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	rnd := rand.New(rand.NewSource(seed))
+
+	return func() time.Duration {
+		return base - spread + time.Duration(rnd.Int63n(int64(2*spread)+1))
+	}
+}
+
+// Retry runs the action of a step again until the until expression passes, or the attempts are exhausted.
+// When until is empty, the test of the step decides it, and when both are empty, only action errors are retried.
+type Retry struct {
+	MaxAttempts  int           `yaml:"max_attempts" validate:"gte=1"`
+	Interval     time.Duration `yaml:"interval"`
+	InitialDelay time.Duration `yaml:"initial_delay"`
+	Until        string        `yaml:"until"`
+}
+
+// WaitFor runs the action of a step on the interval until the condition passes, or the timeout passes.
+// Retry of the step is ignored when wait_for is given.
+type WaitFor struct {
+	Condition string `yaml:"condition" validate:"required"`
+	// Interval is 1s by default, so that the action does not flood the target
+	Interval time.Duration `yaml:"interval"`
+	Timeout  time.Duration `yaml:"timeout" validate:"required"`
+}
+
+const defaultWaitForInterval = time.Second
+
+// interval returns the interval between polls.
+func (wf *WaitFor) interval() time.Duration {
+	if wf.Interval <= 0 {
+		return defaultWaitForInterval
+	}
+	return wf.Interval
 }
 
 type Step struct {
-	Name string           `yaml:"name"`
-	Uses string           `yaml:"uses" validate:"required"`
-	With map[string]any   `yaml:"with"`
-	Test string           `yaml:"test"`
-	Echo string           `yaml:"echo"`
-	Vars map[string]any   `yaml:"vars"`
-	Iter []map[string]any `yaml:"iter"`
-	err  error
-	ctx  StepContext
-	idx  int
-	expr *Expr
+	Name            string           `yaml:"name"`
+	Uses            string           `yaml:"uses" validate:"required_without=UsesTemplate"`
+	With            map[string]any   `yaml:"with"`
+	Test            string           `yaml:"test"`
+	Echo            string           `yaml:"echo"`
+	Vars            map[string]any   `yaml:"vars"`
+	Iter            []map[string]any `yaml:"iter"`
+	ContinueOnError bool             `yaml:"continue_on_error,omitempty"`
+	Timeout         time.Duration    `yaml:"timeout,omitempty"`
+	UsesTemplate    string           `yaml:"uses_template,omitempty"`
+	RtThreshold     time.Duration    `yaml:"rt_threshold,omitempty"`
+	Retry           *Retry           `yaml:"retry,omitempty"`
+	RateLimit       float64          `yaml:"rate_limit,omitempty"`
+	WaitFor         *WaitFor         `yaml:"wait_for,omitempty"`
+	// Expect shortcuts are checked with the test, and reported individually
+	ExpectStatus       int               `yaml:"expect_status,omitempty"`
+	ExpectBodyContains string            `yaml:"expect_body_contains,omitempty"`
+	ExpectHeader       map[string]string `yaml:"expect_header,omitempty"`
+
+	err     error
+	ctx     StepContext
+	idx     int
+	expr    *Expr
+	out     io.Writer
+	run     actionRunner
+	limiter *rate.Limiter
 }
 
 type Job struct {
@@ -135,7 +745,21 @@ type Job struct {
 	Steps    []*Step `yaml:"steps",validate:"required"`
 	Repeat   *Repeat `yaml:"repeat"`
 	Defaults any     `yaml:"defaults"`
+	If       string  `yaml:"if,omitempty"`
 	ctx      *JobContext
+	idx      int
+}
+
+// repeatSteps returns copies of the steps for a repeat, since steps keep the state of the run
+// and repeats run at the same time. The copies share the rate limiter.
+func (j *Job) repeatSteps() []*Step {
+	steps := make([]*Step, len(j.Steps))
+	for i, st := range j.Steps {
+		st.newLimiter()
+		cp := *st
+		steps[i] = &cp
+	}
+	return steps
 }
 
 func (j *Job) Start(ctx JobContext) bool {
@@ -147,23 +771,63 @@ func (j *Job) Start(ctx JobContext) bool {
 	}
 	name, err := expr.EvalTemplate(j.Name, ctx)
 	if err != nil {
-		fmt.Printf("Expr error(job name): %#v\n", err)
+		fmt.Fprintf(ctx.Log, "Expr error(job name): %#v\n", err)
 	} else {
-		fmt.Printf("%s\n", name)
+		fmt.Fprintf(ctx.Log, "%s\n", name)
+	}
+
+	ctx.Result = &JobResult{Name: name, StartTime: time.Now()}
+	ctx.jobID = j.idx
+	ctx.event(Event{Event: EventJobStart})
+	var span trace.Span
+	ctx.spanCtx, span = ctx.startSpan(name)
+	defer func() { endSpan(span, ctx.Result.Status(), "") }()
+
+	if ctx.aborted() {
+		fmt.Fprintf(ctx.Log, "%s\n", color.HiBlackString("aborted: %s", ctx.abortReason()))
+		ctx.Result.Aborted = true
+		return j.finish(&ctx)
+	}
+
+	// before_all and after_all run again as the setup and the teardown of failed jobs
+	if ctx.rerun != nil && j.idx >= 0 && !ctx.rerun[name] {
+		fmt.Fprintf(ctx.Log, "%s\n", color.HiBlackString("skipped: passed in the previous run"))
+		ctx.Result.Skipped = true
+		return j.finish(&ctx)
+	}
+
+	// The job is skipped, not failed, when the if expression is false
+	if j.If != "" {
+		run, err := j.evalIf(expr, ctx)
+		if err != nil {
+			fmt.Fprintf(ctx.Log, "%s: %s\nInput: %s\n", color.RedString("If Error"), err, j.If)
+			ctx.SetFailed()
+		} else if !run {
+			fmt.Fprintf(ctx.Log, "%s\n", color.HiBlackString("skipped: %s", j.If))
+			ctx.Result.Skipped = true
+		}
+		if err != nil || !run {
+			return j.finish(&ctx)
+		}
 	}
 
 	var idx = 0
+steps:
 	for _, st := range j.Steps {
 		st.expr = expr
-		if len(st.Iter) == 0 {
-			st.idx = idx
-			idx += 1
-			st.SetCtx(ctx, nil)
-			st.Do(&ctx)
-			continue
-		}
+		st.out = ctx.Log
+		st.run = ctx.runAction
 		// NOTE: Split JobContext to ExprEnv
-		for _, vars := range st.Iter {
+		iter := st.Iter
+		if len(iter) == 0 {
+			iter = []map[string]any{nil}
+		}
+		for _, vars := range iter {
+			// The rest of steps do not run when the run is aborted
+			if ctx.aborted() {
+				ctx.Result.Aborted = true
+				break steps
+			}
 			st.idx = idx
 			idx += 1
 			st.SetCtx(ctx, vars)
@@ -171,57 +835,162 @@ func (j *Job) Start(ctx JobContext) bool {
 		}
 	}
 
-	return j.ctx.Failed
+	return j.finish(&ctx)
+}
+
+// finish records the job result, and reports whether the job failed.
+func (j *Job) finish(ctx *JobContext) bool {
+	ctx.Result.EndTime = time.Now()
+	ctx.Result.Failed = ctx.Failed
+	if ctx.Results != nil {
+		ctx.Results.Add(ctx.Result)
+	}
+
+	ctx.event(Event{Event: EventJobEnd, Status: ctx.Result.Status(), DurationMs: durationMs(ctx.Result.Duration())})
+
+	return ctx.Failed
+}
+
+// untilPassed evaluates the until expression of repeat against the context of the last run.
+func (j *Job) untilPassed(expr *Expr) bool {
+	out, err := expr.Eval(j.Repeat.Until, *j.ctx)
+	if err != nil {
+		fmt.Fprintf(j.ctx.Log, "%s: %s\nInput: %s\n", color.RedString("Until Error"), err, j.Repeat.Until)
+		return false
+	}
+	passed, ok := out.(bool)
+
+	return ok && passed
+}
+
+// evalIf evaluates the if expression of the job against vars and env.
+func (j *Job) evalIf(expr *Expr, ctx JobContext) (bool, error) {
+	out, err := expr.Eval(j.If, ctx)
+	if err != nil {
+		return false, err
+	}
+	run, ok := out.(bool)
+	if !ok {
+		return false, fmt.Errorf("if must return bool, but got %T", out)
+	}
+
+	return run, nil
 }
 
 func (st *Step) Do(jCtx *JobContext) {
+	start := time.Now()
+	sr := StepResult{Index: st.idx, Uses: st.Uses, Status: StatusNoTest, StartTime: start}
+	var ret map[string]any
+	defer func() {
+		sr.Duration = time.Since(start)
+		jCtx.Result.AddStep(sr)
+		if m := jCtx.Config.Metrics; m != nil && jCtx.Result != nil {
+			m.Observe(jCtx.Result.Name, sr)
+		}
+		jCtx.event(Event{Event: EventStepEnd, StepID: &sr.Index, Step: sr.Name, Uses: sr.Uses, Status: sr.Status,
+			Message: sr.Message, DurationMs: durationMs(sr.Duration)})
+		if jCtx.Config.DumpDir != "" && ret != nil {
+			if err := st.dump(jCtx, sr, ret); err != nil {
+				fmt.Fprintf(st.out, "%s: %s\n", color.RedString("Dump Error"), err)
+			}
+		}
+	}()
+
 	if st.Name == "" {
 		st.Name = "Unknown Step"
 	}
 	name, err := st.expr.EvalTemplate(st.Name, st.ctx)
 	if err != nil {
-		fmt.Printf("Expr error(step name): %#v\n", err)
+		fmt.Fprintf(st.out, "Expr error(step name): %#v\n", err)
 	}
+	sr.Name = name
+	jCtx.event(Event{Event: EventStepStart, StepID: &sr.Index, Step: name, Uses: st.Uses})
+	spanCtx, span := jCtx.startSpan(name, attribute.String("probe.action", st.Uses))
+	var run actionRun
+	defer func() {
+		if run.rt > 0 {
+			span.SetAttributes(attribute.Int64("probe.rt_ms", run.rt.Milliseconds()))
+		}
+		endSpan(span, sr.Status, sr.Message)
+	}()
 
 	expW := st.expr.EvalTemplateMap(st.With, st.ctx)
-	ret, err := RunActions(st.Uses, []string{}, expW, jCtx.Config.Verbose)
+	if st.Uses == "http" {
+		injectTraceContext(spanCtx, expW)
+	}
+	ret, run, err = st.runActionWithRetry(spanCtx, expW, jCtx.Config.Verbose)
+	sr.Attempts = run.attempts
+	sr.Throttled = run.throttled
 	if err != nil {
 		st.err = err
-		jCtx.SetFailed()
+		// The running action is canceled, when the run is aborted
+		if jCtx.aborted() {
+			sr.Status = StatusAborted
+			sr.Message = "aborted: " + jCtx.abortReason()
+			jCtx.Result.Aborted = true
+			fmt.Fprintf(st.out, "%s\n", color.HiBlackString("%2d. aborted: %s", st.idx, name))
+			return
+		}
+		sr.Status = StatusError
+		sr.Message = err.Error()
+		st.setFailed(jCtx, &sr)
 		return
 	}
 
-	// parse json and sets
-	req, okreq := ret["req"].(map[string]any)
-	res, okres := ret["res"].(map[string]any)
-	if okres {
-		body, okbody := res["body"].(string)
-		if okbody && isJSON(body) {
-			res["rawbody"] = body
-			res["body"] = mustMarshalJSON(body)
-		}
-	}
+	req, okreq, res, okres := parseActionResult(ret)
 
 	// set log and logs
 	jCtx.Logs = append(jCtx.Logs, ret)
 	st.updateCtx(jCtx.Logs, req, res)
 
-	if jCtx.Config.Verbose {
+	slow := st.checkRtThreshold(res, run.rt)
+
+	if jCtx.Config.Verbose && !jCtx.Config.Compact {
 		if !okreq || !okres {
-			fmt.Print("sorry, request or response is nil")
-			jCtx.SetFailed()
+			fmt.Fprint(st.out, "sorry, request or response is nil")
+			sr.Status = StatusError
+			sr.Message = "request or response is nil"
+			st.setFailed(jCtx, &sr)
 			return
 		}
 		st.ShowRequestResponse(name)
+		testOK := true
+		for _, r := range st.checkExpect() {
+			jCtx.event(Event{Event: EventTest, StepID: &sr.Index, Step: name, Test: r.name, Passed: &r.passed, Message: r.message})
+			result := color.GreenString("Success")
+			if !r.passed {
+				result = color.RedString("Failure")
+				testOK = false
+				sr.Status = StatusFailure
+				sr.Message = r.message
+				st.setFailed(jCtx, &sr)
+			}
+			fmt.Fprintf(st.out, "Expect: %s (%s)\n", result, r.name)
+		}
 		if st.Test != "" {
-			if ok := st.DoTestWithSequentialPrint(); !ok {
-				jCtx.SetFailed()
+			ok := st.DoTestWithSequentialPrint()
+			jCtx.event(Event{Event: EventTest, StepID: &sr.Index, Step: name, Test: st.Test, Passed: &ok})
+			if !ok {
+				testOK = false
+				sr.Status = StatusFailure
+				sr.Message = fmt.Sprintf("test failed: %s", st.Test)
+				st.setFailed(jCtx, &sr)
 			}
 		}
+		switch {
+		case !testOK:
+		case slow != "":
+			sr.Status = StatusSlow
+			sr.Message = slow
+			st.setFailed(jCtx, &sr)
+			fmt.Fprintf(st.out, "%s: %s\n", color.RedString("Performance Failure"), slow)
+		case st.Test != "" || st.hasExpect():
+			sr.Status = StatusSuccess
+		}
 		if st.Echo != "" {
 			st.DoEchoWithSequentialPrint()
 		}
-		fmt.Println("- - -")
+		fmt.Fprintln(st.out, "- - -")
 		return
 	}
 
@@ -229,34 +998,365 @@ func (st *Step) Do(jCtx *JobContext) {
 	//   1. ✔︎ Step name
 	num := color.HiBlackString(fmt.Sprintf("%2d.", st.idx))
 	output := fmt.Sprintf("%s %%s %s", num, name)
-	if st.Test != "" {
-		str, ok := st.DoTest()
-		if ok {
-			output = fmt.Sprintf(output+"\n", color.GreenString("✔︎ "))
-		} else {
-			output = fmt.Sprintf(output+"\n"+str+"\n", color.RedString("✘ "))
-			jCtx.SetFailed()
+	if run.attempts > 1 {
+		output += color.HiBlackString(fmt.Sprintf(" (attempts: %d)", run.attempts))
+	}
+	str, testOK := "", true
+	for _, r := range st.checkExpect() {
+		jCtx.event(Event{Event: EventTest, StepID: &sr.Index, Step: name, Test: r.name, Passed: &r.passed, Message: r.message})
+		if !r.passed {
+			// 7 spaces
+			str += fmt.Sprintf("       %s\n", r.message)
+			testOK = false
 		}
-	} else {
-		output = fmt.Sprintf(output+"\n", color.BlueString("▲ "))
 	}
-	fmt.Print(output)
+	if st.Test != "" {
+		testStr, ok := st.DoTest()
+		jCtx.event(Event{Event: EventTest, StepID: &sr.Index, Step: name, Test: st.Test, Passed: &ok,
+			Message: strings.TrimSpace(testStr)})
+		str += testStr
+		testOK = testOK && ok
+	}
+	mark, detail := color.BlueString("▲ "), ""
+	switch {
+	case !testOK:
+		sr.Status = StatusFailure
+		sr.Message = strings.TrimSpace(str)
+		st.setFailed(jCtx, &sr)
+		mark, detail = failedMark(sr.Status), str
+	case slow != "":
+		sr.Status = StatusSlow
+		sr.Message = slow
+		st.setFailed(jCtx, &sr)
+		mark, detail = failedMark(sr.Status), slow
+	case st.Test != "" || st.hasExpect():
+		sr.Status = StatusSuccess
+		mark = color.GreenString("✔︎ ")
+	}
+
+	// The compact mode prints only a line with the rt per step
+	if jCtx.Config.Compact {
+		fmt.Fprintf(st.out, output+"%s\n", mark, color.HiBlackString(" (%s)", run.rt.Round(time.Millisecond)))
+		return
+	}
+
+	output = fmt.Sprintf(output+"\n", mark)
+	if detail != "" {
+		output += detail + "\n"
+	}
+	fmt.Fprint(st.out, output)
 
 	if st.Echo != "" {
 		st.DoEcho()
 	}
 }
 
+// dumpNameRegexp matches characters replaced in dump file names
+var dumpNameRegexp = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// dump writes the request and response of the step as json, in which secrets are masked.
+// The file is named by the job, the index and the name of the step, and the start time for repeats.
+func (st *Step) dump(jCtx *JobContext, sr StepResult, ret map[string]any) error {
+	jobName := ""
+	if jCtx.Result != nil {
+		jobName = jCtx.Result.Name
+	}
+	name := fmt.Sprintf("%s-%d-%s-%s.json", jobName, sr.Index, sr.Name, sr.StartTime.Format("20060102T150405.000000000"))
+	path := filepath.Join(jCtx.Config.DumpDir, dumpNameRegexp.ReplaceAllString(name, "_"))
+
+	b, err := json.MarshalIndent(map[string]any{
+		"job":     jobName,
+		"step":    sr.Name,
+		"index":   sr.Index,
+		"uses":    sr.Uses,
+		"status":  sr.Status,
+		"message": sr.Message,
+		"req":     ret["req"],
+		"res":     ret["res"],
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = newMaskWriter(f, jCtx.secrets).Write(b)
+	return err
+}
+
+// actionRun is the measurement of the action runs of a step. Repeats of a job run the same step
+// at the same time, so that it is returned by each run instead of kept in the step.
+type actionRun struct {
+	attempts int
+	// rt is the time taken by the last attempt
+	rt time.Duration
+	// throttled is true when any attempt waited for the rate limit
+	throttled bool
+}
+
+// add returns the measurement with the attempt added.
+func (r actionRun) add(attempt actionRun) actionRun {
+	return actionRun{attempts: r.attempts + 1, rt: attempt.rt, throttled: r.throttled || attempt.throttled}
+}
+
+// runAction runs the action of the step within the step timeout, if any, and returns the measurement of the attempt.
+func (st *Step) runAction(ctx context.Context, with map[string]any, verbose bool) (map[string]any, actionRun, error) {
+	if st.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, st.Timeout)
+		defer cancel()
+	}
+
+	throttled := st.throttle()
+
+	start := time.Now()
+	run := st.run
+	if run == nil {
+		run = RunActions
+	}
+	ret, err := run(ctx, st.Uses, []string{}, with, verbose)
+	attempt := actionRun{attempts: 1, rt: time.Since(start), throttled: throttled}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return nil, attempt, &TimeoutError{Action: st.Uses, Timeout: st.Timeout}
+	}
+	if err != nil {
+		return nil, attempt, &ActionError{Action: st.Uses, Err: err}
+	}
+
+	return ret, attempt, nil
+}
+
+// checkEnv returns an error when expressions in the params refer to environment variables that are not set.
+func checkEnv(with map[string]any, env map[string]any) error {
+	missing := map[string]bool{}
+	var walk func(v any)
+	walk = func(v any) {
+		switch val := v.(type) {
+		case string:
+			for _, tmpl := range templateRegexp.FindAllString(val, -1) {
+				// an expression with a fallback value handles the missing one by itself
+				if strings.Contains(tmpl, "??") {
+					continue
+				}
+				for _, m := range envRefRegexp.FindAllStringSubmatch(tmpl, -1) {
+					if _, ok := env[m[1]]; !ok {
+						missing[m[1]] = true
+					}
+				}
+			}
+		case map[string]any:
+			for _, vv := range val {
+				walk(vv)
+			}
+		case []any:
+			for _, vv := range val {
+				walk(vv)
+			}
+		}
+	}
+	walk(with)
+
+	if len(missing) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(missing))
+	for name := range missing {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return fmt.Errorf("environment variable is not set: %s", strings.Join(names, ", "))
+}
+
+// useColor reports whether the output can be colored. Color is disabled by config, by the NO_COLOR
+// environment variable, or when the log is a file that is not a terminal unless it is forced.
+func useColor(c Config) bool {
+	if c.NoColor || os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	if c.ForceColor {
+		return true
+	}
+	if _, ok := c.Log.(*os.File); ok {
+		return isTerminal(c.Log)
+	}
+	return true
+}
+
+// isTerminal reports whether w is a terminal.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	return ok && (isatty.IsTerminal(f.Fd()) || isatty.IsCygwinTerminal(f.Fd()))
+}
+
+// throttle waits until the rate limit of the step allows the next run, and reports whether it waited.
+// The limiter is shared by the repeats of the job, since they run the same step.
+func (st *Step) throttle() bool {
+	if st.RateLimit <= 0 {
+		return false
+	}
+	st.newLimiter()
+
+	d := st.limiter.Reserve().Delay()
+	if d <= 0 {
+		return false
+	}
+	time.Sleep(d)
+
+	return true
+}
+
+// newLimiter creates the limiter of the rate limit unless it exists.
+func (st *Step) newLimiter() {
+	if st.RateLimit > 0 && st.limiter == nil {
+		st.limiter = rate.NewLimiter(rate.Limit(st.RateLimit), 1)
+	}
+}
+
+// runActionWithRetry runs the action, and runs it again according to the retry of the step.
+// It returns the result of the last attempt and the measurement of attempts.
+func (st *Step) runActionWithRetry(ctx context.Context, with map[string]any, verbose bool) (map[string]any, actionRun, error) {
+	if st.WaitFor != nil {
+		return st.waitFor(ctx, with, verbose)
+	}
+	if st.Retry == nil {
+		return st.runAction(ctx, with, verbose)
+	}
+
+	var run actionRun
+	sleepContext(ctx, st.Retry.InitialDelay)
+	for {
+		ret, attempt, err := st.runAction(ctx, with, verbose)
+		run = run.add(attempt)
+		if (err == nil && st.retryPassed(ret)) || run.attempts >= st.Retry.MaxAttempts || ctx.Err() != nil {
+			return ret, run, err
+		}
+		sleepContext(ctx, st.Retry.Interval)
+	}
+}
+
+// waitFor polls the action until the condition of wait_for passes, and returns the result of the last poll
+// and the measurement of polls. It fails when the next poll would start after the timeout.
+func (st *Step) waitFor(ctx context.Context, with map[string]any, verbose bool) (map[string]any, actionRun, error) {
+	var run actionRun
+	deadline := time.Now().Add(st.WaitFor.Timeout)
+	for {
+		ret, attempt, err := st.runAction(ctx, with, verbose)
+		run = run.add(attempt)
+		if err == nil && st.conditionPassed(st.WaitFor.Condition, ret) {
+			return ret, run, nil
+		}
+		if ctx.Err() != nil {
+			return nil, run, ctx.Err()
+		}
+		if time.Now().Add(st.WaitFor.interval()).After(deadline) {
+			return nil, run, &WaitTimeoutError{Condition: st.WaitFor.Condition, Timeout: st.WaitFor.Timeout, Polls: run.attempts}
+		}
+		sleepContext(ctx, st.WaitFor.interval())
+	}
+}
+
+// sleepContext pauses for the duration, or until the context is done.
+func sleepContext(ctx context.Context, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+	case <-t.C:
+	}
+}
+
+// retryPassed evaluates the until expression, or the test when until is empty, against the action result.
+func (st *Step) retryPassed(ret map[string]any) bool {
+	cond := st.Retry.Until
+	if cond == "" {
+		cond = st.Test
+	}
+	if cond == "" {
+		return true
+	}
+
+	return st.conditionPassed(cond, ret)
+}
+
+// conditionPassed evaluates the expression against the action result.
+func (st *Step) conditionPassed(cond string, ret map[string]any) bool {
+	ctx := st.ctx
+	ctx.Req, _, ctx.Res, _ = parseActionResult(ret)
+	out, err := st.expr.Eval(cond, ctx)
+	if err != nil {
+		return false
+	}
+	passed, ok := out.(bool)
+
+	return ok && passed
+}
+
+// parseActionResult returns req and res of the action result, and parses the json body of res.
+func parseActionResult(ret map[string]any) (map[string]any, bool, map[string]any, bool) {
+	req, okreq := ret["req"].(map[string]any)
+	res, okres := ret["res"].(map[string]any)
+	if okres {
+		body, okbody := res["body"].(string)
+		if okbody && isJSON(body) {
+			res["rawbody"] = body
+			res["body"] = mustMarshalJSON(body)
+		}
+	}
+
+	return req, okreq, res, okres
+}
+
+// checkRtThreshold returns a message when the response time exceeds the threshold of the step.
+// The `rt` reported by the action is used if any, otherwise the time taken to run the action.
+func (st *Step) checkRtThreshold(res map[string]any, measured time.Duration) string {
+	if st.RtThreshold <= 0 {
+		return ""
+	}
+
+	rt := measured
+	if s, ok := res["rt"].(string); ok {
+		if d, err := time.ParseDuration(s); err == nil {
+			rt = d
+		}
+	}
+	if rt <= st.RtThreshold {
+		return ""
+	}
+
+	return fmt.Sprintf("response time %s exceeded rt_threshold %s", rt, st.RtThreshold)
+}
+
+func failedMark(status string) string {
+	if status == StatusWarning {
+		return color.YellowString("⚠ ")
+	}
+	return color.RedString("✘ ")
+}
+
+// setFailed marks the job as failed, or only the step as a warning when it continues on error.
+func (st *Step) setFailed(jCtx *JobContext, sr *StepResult) {
+	if st.ContinueOnError {
+		sr.Status = StatusWarning
+		return
+	}
+	jCtx.SetFailed()
+}
+
 func (st *Step) DoTestWithSequentialPrint() bool {
 	exprOut, err := st.expr.Eval(st.Test, st.ctx)
 	if err != nil {
-		fmt.Printf("%s: %s\nInput: %s\n", color.RedString("Test Error"), err, st.Test)
+		fmt.Fprintf(st.out, "%s: %s\nInput: %s\n", color.RedString("Test Error"), err, st.Test)
 		return false
 	}
 
 	boolOutput, boolOk := exprOut.(bool)
 	if !boolOk {
-		fmt.Printf("Test: `%s` = %s\n", st.Test, exprOut)
+		fmt.Fprintf(st.out, "Test: `%s` = %s\n", st.Test, exprOut)
 		return false
 	}
 
@@ -264,24 +1364,24 @@ func (st *Step) DoTestWithSequentialPrint() bool {
 	if !boolOutput {
 		boolResultStr = color.RedString("Failure")
 	}
-	fmt.Printf("Test: %s (input: %s, env: %#v)\n", boolResultStr, st.Test, st.ctx)
+	fmt.Fprintf(st.out, "Test: %s (input: %s, env: %#v)\n", boolResultStr, st.Test, st.ctx)
 
-	return boolOk
+	return boolOutput
 }
 
 func (st *Step) DoEchoWithSequentialPrint() {
 	exprOut, err := st.expr.Eval(st.Echo, st.ctx)
 	if err != nil {
-		fmt.Printf("%s: %#v (input: %s)\n", color.RedString("Echo Error"), err, st.Echo)
+		fmt.Fprintf(st.out, "%s: %#v (input: %s)\n", color.RedString("Echo Error"), err, st.Echo)
 	} else {
-		fmt.Printf("Echo: %s\n", exprOut)
+		fmt.Fprintf(st.out, "Echo: %s\n", exprOut)
 	}
 }
 
 func (st *Step) DoTest() (string, bool) {
 	exprOut, err := st.expr.Eval(st.Test, st.ctx)
 	if err != nil {
-		return fmt.Sprintf("Test\nerror: %#v\n", err), false
+		return fmt.Sprintf("Test\nerror: %s\n", err), false
 	}
 
 	boolOutput, boolOk := exprOut.(bool)
@@ -302,10 +1402,10 @@ func (st *Step) DoTest() (string, bool) {
 func (st *Step) DoEcho() {
 	exprOut, err := st.expr.Eval(st.Echo, st.ctx)
 	if err != nil {
-		fmt.Printf("Echo\nerror: %#v\n", err)
+		fmt.Fprintf(st.out, "Echo\nerror: %#v\n", err)
 	} else {
 		// 7 spaces
-		fmt.Printf("       %s\n", exprOut)
+		fmt.Fprintf(st.out, "       %s\n", exprOut)
 	}
 }
 
@@ -316,6 +1416,7 @@ func (st *Step) SetCtx(j JobContext, override map[string]any) {
 	}
 	st.ctx = StepContext{
 		Vars: vers,
+		Env:  j.Env,
 		Logs: j.Logs,
 	}
 }
@@ -327,30 +1428,29 @@ func (st *Step) updateCtx(logs []map[string]any, req, res map[string]any) {
 }
 
 func (st *Step) ShowRequestResponse(name string) {
-	fmt.Printf("--- Step %d: %s\nRequest:\n", st.idx, name)
+	fmt.Fprintf(st.out, "--- Step %d: %s\nRequest:\n", st.idx, name)
+	st.showSorted(st.ctx.Req)
+	fmt.Fprintf(st.out, "Response:\n")
+	st.showSorted(st.ctx.Res)
+}
 
-	for k, v := range st.ctx.Req {
-		nested, ok := v.(map[string]any)
-		if ok {
-			fmt.Printf("  %s:\n", k)
-			for kk, vv := range nested {
-				fmt.Printf("    %s: %#v\n", kk, vv)
-			}
-		} else {
-			fmt.Printf("  %s: %#v\n", k, v)
-		}
+// showSorted prints the keys in sorted order, and nested maps as flat keys, so that the output is the same every run.
+func (st *Step) showSorted(m map[string]any) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
 	}
-	fmt.Printf("Response:\n")
+	sort.Strings(keys)
 
-	for k, v := range st.ctx.Res {
-		nested, ok := v.(map[string]any)
+	for _, k := range keys {
+		nested, ok := m[k].(map[string]any)
 		if ok {
-			fmt.Printf("  %s:\n", k)
-			for kk, vv := range nested {
-				fmt.Printf("    %s: %#v\n", kk, vv)
+			fmt.Fprintf(st.out, "  %s:\n", k)
+			for _, kv := range FlattenInterfaceSorted(nested) {
+				fmt.Fprintf(st.out, "    %s: %#v\n", kv.Key, kv.Value)
 			}
 		} else {
-			fmt.Printf("  %s: %#v\n", k, v)
+			fmt.Fprintf(st.out, "  %s: %#v\n", k, m[k])
 		}
 	}
 }
@@ -1,10 +1,24 @@
 package probe
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
 	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/fatih/color"
 )
 
 func TestEnv(t *testing.T) {
@@ -75,7 +89,7 @@ func TestEvalVars(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			actual, err := tt.wf.evalVars()
+			actual, err := tt.wf.evalVars("")
 			if err != nil && err.Error() != tt.err.Error() {
 				t.Errorf("expected error %+v, got %+v", tt.err, err)
 			}
@@ -85,3 +99,1111 @@ func TestEvalVars(t *testing.T) {
 		})
 	}
 }
+
+func TestDryRun(t *testing.T) {
+	tests := []struct {
+		name     string
+		wf       *Workflow
+		expected string
+		err      string
+	}{
+		{
+			name: "known actions",
+			wf: &Workflow{
+				Name: "Test",
+				Jobs: []Job{
+					{
+						Name: "Job {vars.name}",
+						Steps: []*Step{
+							{Name: "Get", Uses: "http", With: map[string]any{"url": "http://localhost", "get": "/"}},
+							{Uses: "hello"},
+						},
+					},
+				},
+				Vars: map[string]any{"name": "A"},
+			},
+			expected: "Job A\n 0. Get (uses: http)\n 1. Unknown Step (uses: hello)\n",
+		},
+		{
+			name: "unknown action",
+			wf: &Workflow{
+				Name: "Test",
+				Jobs: []Job{
+					{Name: "Job", Steps: []*Step{{Uses: "unknown"}}},
+				},
+			},
+			expected: "Job\n",
+			err:      "jobs[0].steps[0]: unknown action 'unknown'",
+		},
+		{
+			name: "missing with params",
+			wf: &Workflow{
+				Name: "Test",
+				Jobs: []Job{
+					{Name: "Job", Steps: []*Step{
+						{Name: "Get", Uses: "http", With: map[string]any{"url": "http://localhost"}},
+						{Name: "Connect", Uses: "tcp", With: map[string]any{"host": "localhost", "port": ""}},
+					}},
+				},
+			},
+			expected: "Job\n 0. Get (uses: http)\n",
+			err:      "jobs[0].steps[1]: action 'tcp' requires with: port",
+		},
+		{
+			name: "missing with of the hook",
+			wf: &Workflow{
+				Name:      "Test",
+				BeforeAll: []*Step{{Name: "Lookup", Uses: "dns"}},
+			},
+			expected: "before_all\n",
+			err:      "before_all.steps[0]: action 'dns' requires with: name",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			tt.wf.env = map[string]string{}
+			err := tt.wf.Start(Config{Log: &buf, DryRun: true})
+			if tt.err == "" && err != nil {
+				t.Errorf("unexpected error %s", err)
+			}
+			if tt.err != "" && (err == nil || err.Error() != tt.err) {
+				t.Errorf("expected error %s, got %v", tt.err, err)
+			}
+			if buf.String() != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, buf.String())
+			}
+		})
+	}
+}
+
+func stubRunActions(t *testing.T, fn func(ctx context.Context, name string, args []string, with map[string]any, verbose bool) (map[string]any, error)) {
+	t.Helper()
+	orig := newActionRunner
+	newActionRunner = func(io.Writer) (actionRunner, func()) { return fn, func() {} }
+	t.Cleanup(func() { newActionRunner = orig })
+}
+
+func TestStepContinueOnError(t *testing.T) {
+	stubRunActions(t, func(ctx context.Context, name string, args []string, with map[string]any, verbose bool) (map[string]any, error) {
+		if name == "fail" {
+			return nil, fmt.Errorf("action failed")
+		}
+		return map[string]any{"req": map[string]any{}, "res": map[string]any{"code": 500}}, nil
+	})
+
+	tests := []struct {
+		name     string
+		steps    []*Step
+		failed   bool
+		statuses []string
+	}{
+		{
+			name:     "action error fails the job",
+			steps:    []*Step{{Uses: "fail"}, {Uses: "hello"}},
+			failed:   true,
+			statuses: []string{StatusError, StatusNoTest},
+		},
+		{
+			name:     "action error continues on error",
+			steps:    []*Step{{Uses: "fail", ContinueOnError: true}, {Uses: "hello"}},
+			failed:   false,
+			statuses: []string{StatusWarning, StatusNoTest},
+		},
+		{
+			name:     "test failure continues on error",
+			steps:    []*Step{{Uses: "hello", Test: "res.code == 200", ContinueOnError: true}, {Uses: "hello", Test: "res.code == 500"}},
+			failed:   false,
+			statuses: []string{StatusWarning, StatusSuccess},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			wf := &Workflow{Name: "Test", Jobs: []Job{{Name: "Job", Steps: tt.steps}}, env: map[string]string{}}
+			if err := wf.Start(Config{Log: &buf}); err != nil {
+				t.Fatalf("unexpected error %s", err)
+			}
+			if (wf.exitStatus != ExitSuccess) != tt.failed {
+				t.Errorf("expected failed %t, got exit status %d", tt.failed, wf.exitStatus)
+			}
+			steps := wf.Result().Jobs[0].Steps
+			for i, status := range tt.statuses {
+				if steps[i].Status != status {
+					t.Errorf("steps[%d]: expected status %s, got %s", i, status, steps[i].Status)
+				}
+			}
+		})
+	}
+}
+
+func TestStepTestError(t *testing.T) {
+	stubRunActions(t, func(ctx context.Context, name string, args []string, with map[string]any, verbose bool) (map[string]any, error) {
+		return map[string]any{"req": map[string]any{}, "res": map[string]any{"body": "ok"}}, nil
+	})
+
+	var buf bytes.Buffer
+	steps := []*Step{{Uses: "http", Test: `match("(", res.body)`}}
+	wf := &Workflow{Name: "Test", Jobs: []Job{{Name: "Job", Steps: steps}}, env: map[string]string{}}
+	if err := wf.Start(Config{Log: &buf}); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	got := wf.Result().Jobs[0].Steps[0]
+	if got.Status != StatusFailure || !strings.Contains(got.Message, `match: invalid pattern "("`) {
+		t.Errorf("expected the test error of the invalid pattern, got %+v", got)
+	}
+}
+
+func TestStepTimeout(t *testing.T) {
+	stubRunActions(t, func(ctx context.Context, name string, args []string, with map[string]any, verbose bool) (map[string]any, error) {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Second):
+			return map[string]any{"req": map[string]any{}, "res": map[string]any{}}, nil
+		}
+	})
+
+	var buf bytes.Buffer
+	steps := []*Step{{Uses: "slow", Timeout: 10 * time.Millisecond}}
+	wf := &Workflow{Name: "Test", Jobs: []Job{{Name: "Job", Steps: steps}}, env: map[string]string{}}
+	if err := wf.Start(Config{Log: &buf}); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	var te *TimeoutError
+	if !errors.As(steps[0].err, &te) {
+		t.Fatalf("expected TimeoutError, got %#v", steps[0].err)
+	}
+	got := wf.Result().Jobs[0].Steps[0]
+	expected := "action 'slow' timed out after 10ms"
+	if got.Status != StatusError || got.Message != expected {
+		t.Errorf("expected %s: %s, got %s: %s", StatusError, expected, got.Status, got.Message)
+	}
+	if wf.exitStatus != ExitActionError {
+		t.Errorf("expected exit status %d, got %d", ExitActionError, wf.exitStatus)
+	}
+}
+
+func TestStepTestStatus(t *testing.T) {
+	stubRunActions(t, func(ctx context.Context, name string, args []string, with map[string]any, verbose bool) (map[string]any, error) {
+		return map[string]any{"req": map[string]any{}, "res": map[string]any{"code": 500}}, nil
+	})
+
+	for _, verbose := range []bool{false, true} {
+		t.Run(fmt.Sprintf("verbose %t", verbose), func(t *testing.T) {
+			steps := []*Step{
+				{Name: "Passed", Uses: "http", Test: "res.code == 500"},
+				{Name: "Failed", Uses: "http", Test: "res.code == 200"},
+				{Name: "Expected", Uses: "http", ExpectStatus: 200},
+			}
+			wf := &Workflow{Name: "Test", Jobs: []Job{{Name: "Job", Steps: steps}}, env: map[string]string{}}
+			if err := wf.Start(Config{Log: &bytes.Buffer{}, Verbose: verbose}); err != nil {
+				t.Fatalf("unexpected error %s", err)
+			}
+
+			var got []string
+			for _, sr := range wf.Result().Jobs[0].Steps {
+				got = append(got, sr.Status)
+			}
+			expected := []string{StatusSuccess, StatusFailure, StatusFailure}
+			if !reflect.DeepEqual(got, expected) {
+				t.Errorf("expected statuses %v, got %v", expected, got)
+			}
+			if wf.exitStatus != ExitTestFailure {
+				t.Errorf("expected exit status %d, got %d", ExitTestFailure, wf.exitStatus)
+			}
+		})
+	}
+}
+
+func TestStartConfigError(t *testing.T) {
+	wf := &Workflow{Name: "Test", Jobs: []Job{{Name: "Job", Steps: []*Step{{Uses: "hello"}}}}, env: map[string]string{}}
+	err := wf.Start(Config{Log: &bytes.Buffer{}, OutputFormat: "yaml"})
+
+	var ce *ConfigError
+	if !errors.As(err, &ce) {
+		t.Errorf("expected ConfigError, got %#v", err)
+	}
+}
+
+func TestStartSummaryPath(t *testing.T) {
+	runs := 0
+	stubRunActions(t, func(ctx context.Context, name string, args []string, with map[string]any, verbose bool) (map[string]any, error) {
+		runs++
+		return map[string]any{"req": map[string]any{}, "res": map[string]any{}}, nil
+	})
+
+	dir := t.TempDir()
+	tests := []struct {
+		name string
+		path string
+		runs int
+	}{
+		{name: "unknown extension", path: filepath.Join(dir, "summary.txt")},
+		{name: "missing directory", path: filepath.Join(dir, "missing", "summary.csv")},
+		{name: "valid path", path: filepath.Join(dir, "summary.csv"), runs: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runs = 0
+			wf := &Workflow{Name: "Test", Jobs: []Job{{Name: "Job", Steps: []*Step{{Name: "Get", Uses: "http"}}}}, env: map[string]string{}}
+			err := wf.Start(Config{Log: &bytes.Buffer{}, SummaryPath: tt.path})
+
+			var ce *ConfigError
+			if tt.runs == 0 && !errors.As(err, &ce) {
+				t.Errorf("expected ConfigError, got %#v", err)
+			}
+			if tt.runs > 0 && err != nil {
+				t.Errorf("unexpected error %s", err)
+			}
+			if runs != tt.runs {
+				t.Errorf("expected %d runs before the summary is written, got %d", tt.runs, runs)
+			}
+		})
+	}
+}
+
+func TestStepRtThreshold(t *testing.T) {
+	stubRunActions(t, func(ctx context.Context, name string, args []string, with map[string]any, verbose bool) (map[string]any, error) {
+		res := map[string]any{"code": 200}
+		switch name {
+		case "sleep":
+			time.Sleep(30 * time.Millisecond)
+		case "reported":
+			res["rt"] = "2s"
+		}
+		return map[string]any{"req": map[string]any{}, "res": res}, nil
+	})
+
+	tests := []struct {
+		name    string
+		step    *Step
+		status  string
+		message string
+		failed  bool
+	}{
+		{
+			name:    "measured time exceeds threshold",
+			step:    &Step{Uses: "sleep", Test: "res.code == 200", RtThreshold: 10 * time.Millisecond},
+			status:  StatusSlow,
+			message: "exceeded rt_threshold 10ms",
+			failed:  true,
+		},
+		{
+			name:    "reported rt exceeds threshold",
+			step:    &Step{Uses: "reported", RtThreshold: time.Second},
+			status:  StatusSlow,
+			message: "response time 2s exceeded rt_threshold 1s",
+			failed:  true,
+		},
+		{
+			name:   "within threshold",
+			step:   &Step{Uses: "fast", Test: "res.code == 200", RtThreshold: time.Second},
+			status: StatusSuccess,
+		},
+		{
+			name:    "test failure takes precedence",
+			step:    &Step{Uses: "reported", Test: "res.code == 500", RtThreshold: time.Second},
+			status:  StatusFailure,
+			message: "response:",
+			failed:  true,
+		},
+		{
+			name:    "continue on error",
+			step:    &Step{Uses: "reported", RtThreshold: time.Second, ContinueOnError: true},
+			status:  StatusWarning,
+			message: "response time 2s exceeded rt_threshold 1s",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			wf := &Workflow{Name: "Test", Jobs: []Job{{Name: "Job", Steps: []*Step{tt.step}}}, env: map[string]string{}}
+			if err := wf.Start(Config{Log: &buf}); err != nil {
+				t.Fatalf("unexpected error %s", err)
+			}
+
+			got := wf.Result().Jobs[0]
+			if got.Steps[0].Status != tt.status || !strings.Contains(got.Steps[0].Message, tt.message) {
+				t.Errorf("expected %s: %s, got %s: %s", tt.status, tt.message, got.Steps[0].Status, got.Steps[0].Message)
+			}
+			if got.Failed != tt.failed {
+				t.Errorf("expected failed %t, got %t", tt.failed, got.Failed)
+			}
+		})
+	}
+}
+
+func TestStepRetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		step     *Step
+		status   string
+		attempts int
+		calls    int
+	}{
+		{
+			name:     "until passes on the third attempt",
+			step:     &Step{Uses: "counter", Retry: &Retry{MaxAttempts: 5, Until: "res.count >= 3"}},
+			status:   StatusNoTest,
+			attempts: 3,
+			calls:    3,
+		},
+		{
+			name:     "test decides when until is empty",
+			step:     &Step{Uses: "counter", Test: "res.count == 2", Retry: &Retry{MaxAttempts: 5}},
+			status:   StatusSuccess,
+			attempts: 2,
+			calls:    2,
+		},
+		{
+			name:     "attempts are exhausted",
+			step:     &Step{Uses: "counter", Test: "res.count > 10", Retry: &Retry{MaxAttempts: 3, Interval: time.Millisecond}},
+			status:   StatusFailure,
+			attempts: 3,
+			calls:    3,
+		},
+		{
+			name:     "action errors are retried",
+			step:     &Step{Uses: "flaky", Retry: &Retry{MaxAttempts: 3}},
+			status:   StatusNoTest,
+			attempts: 2,
+			calls:    2,
+		},
+		{
+			name:     "without retry",
+			step:     &Step{Uses: "counter", Test: "res.count > 10"},
+			status:   StatusFailure,
+			attempts: 1,
+			calls:    1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			stubRunActions(t, func(ctx context.Context, name string, args []string, with map[string]any, verbose bool) (map[string]any, error) {
+				calls++
+				if name == "flaky" && calls == 1 {
+					return nil, fmt.Errorf("connection refused")
+				}
+				return map[string]any{"req": map[string]any{}, "res": map[string]any{"count": calls}}, nil
+			})
+
+			var buf bytes.Buffer
+			wf := &Workflow{Name: "Test", Jobs: []Job{{Name: "Job", Steps: []*Step{tt.step}}}, env: map[string]string{}}
+			if err := wf.Start(Config{Log: &buf}); err != nil {
+				t.Fatalf("unexpected error %s", err)
+			}
+
+			got := wf.Result().Jobs[0].Steps[0]
+			if got.Status != tt.status || got.Attempts != tt.attempts {
+				t.Errorf("expected %s with %d attempts, got %s with %d attempts", tt.status, tt.attempts, got.Status, got.Attempts)
+			}
+			if calls != tt.calls {
+				t.Errorf("expected %d calls, got %d", tt.calls, calls)
+			}
+		})
+	}
+}
+
+func TestNoColor(t *testing.T) {
+	stubRunActions(t, func(ctx context.Context, name string, args []string, with map[string]any, verbose bool) (map[string]any, error) {
+		return map[string]any{"req": map[string]any{}, "res": map[string]any{"code": 200}}, nil
+	})
+	orig := color.NoColor
+	t.Cleanup(func() { color.NoColor = orig })
+
+	tests := []struct {
+		name  string
+		c     Config
+		env   string
+		file  bool
+		color bool
+	}{
+		{name: "colored", c: Config{}, color: true},
+		{name: "no-color option", c: Config{NoColor: true}},
+		{name: "NO_COLOR env", c: Config{}, env: "1"},
+		{name: "file", c: Config{}, file: true},
+		{name: "file with force-color option", c: Config{ForceColor: true}, file: true, color: true},
+		{name: "no-color wins over force-color", c: Config{NoColor: true, ForceColor: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// stdout of tests is not a terminal, like the output file
+			color.NoColor = tt.file
+			t.Setenv("NO_COLOR", tt.env)
+			if tt.env == "" {
+				os.Unsetenv("NO_COLOR")
+			}
+
+			var buf bytes.Buffer
+			tt.c.Log = &buf
+			if tt.file {
+				f, err := os.Create(filepath.Join(t.TempDir(), "report.txt"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer f.Close()
+				tt.c.Log = f
+			}
+			steps := []*Step{{Uses: "hello", Test: "res.code == 200"}}
+			wf := &Workflow{Name: "Test", Jobs: []Job{{Name: "Job", Steps: steps}}, env: map[string]string{}}
+			if err := wf.Start(tt.c); err != nil {
+				t.Fatalf("unexpected error %s", err)
+			}
+
+			out := buf.String()
+			if f, ok := tt.c.Log.(*os.File); ok {
+				b, _ := os.ReadFile(f.Name())
+				out = string(b)
+			}
+			if got := strings.Contains(out, "\x1b["); got != tt.color {
+				t.Errorf("expected colored %t, got %q", tt.color, out)
+			}
+		})
+	}
+}
+
+func TestStepReferencesPreviousRequest(t *testing.T) {
+	var received map[string]any
+	stubRunActions(t, func(ctx context.Context, name string, args []string, with map[string]any, verbose bool) (map[string]any, error) {
+		received = with
+		return map[string]any{"req": with, "res": map[string]any{"code": 201}}, nil
+	})
+
+	steps := []*Step{
+		{Uses: "http", With: map[string]any{"url": "http://localhost/users", "body": "alice"}},
+		{
+			Uses: "http",
+			With: map[string]any{"url": "{steps[0].req.url}/{steps[0].req.body}"},
+			Test: `steps[0].req.body == "alice" && steps[0].res.code == 201`,
+		},
+	}
+	var buf bytes.Buffer
+	wf := &Workflow{Name: "Test", Jobs: []Job{{Name: "Job", Steps: steps}}, env: map[string]string{}}
+	if err := wf.Start(Config{Log: &buf}); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	if received["url"] != "http://localhost/users/alice" {
+		t.Errorf("expected url to refer the previous request, got %#v", received["url"])
+	}
+	if got := wf.Result().Jobs[0].Steps[1].Status; got != StatusSuccess {
+		t.Errorf("expected %s, got %s: %s", StatusSuccess, got, buf.String())
+	}
+}
+
+func TestStepWithEnv(t *testing.T) {
+	var received map[string]any
+	stubRunActions(t, func(ctx context.Context, name string, args []string, with map[string]any, verbose bool) (map[string]any, error) {
+		received = with
+		return map[string]any{"req": with, "res": map[string]any{}}, nil
+	})
+
+	tests := []struct {
+		name     string
+		with     map[string]any
+		expected map[string]any
+		err      string
+	}{
+		{
+			name: "present",
+			with: map[string]any{
+				"token":   "{env.API_TOKEN}",
+				"headers": map[string]any{"authorization": "Bearer {env.API_TOKEN}"},
+			},
+			expected: map[string]any{
+				"token":   "secret",
+				"headers": map[string]any{"authorization": "Bearer secret"},
+			},
+		},
+		{
+			name: "missing",
+			with: map[string]any{"token": "{env.MISSING_B}:{env.MISSING_A}", "user": "{env.MISSING_C ?? 'guest'}"},
+			err:  "jobs[0].steps[0]: environment variable is not set: MISSING_A, MISSING_B",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			received = nil
+			var buf bytes.Buffer
+			steps := []*Step{{Uses: "http", With: tt.with}}
+			wf := &Workflow{Name: "Test", Jobs: []Job{{Name: "Job", Steps: steps}}, env: map[string]string{"API_TOKEN": "secret"}}
+			err := wf.Start(Config{Log: &buf})
+
+			var ce *ConfigError
+			if tt.err != "" && (!errors.As(err, &ce) || err.Error() != tt.err) {
+				t.Errorf("expected ConfigError %s, got %#v", tt.err, err)
+			}
+			if tt.err == "" && err != nil {
+				t.Fatalf("unexpected error %s", err)
+			}
+			if tt.err != "" && received != nil {
+				t.Errorf("expected the action not to run, got %#v", received)
+			}
+			if tt.expected != nil && !reflect.DeepEqual(received, tt.expected) {
+				t.Errorf("expected %#v, got %#v", tt.expected, received)
+			}
+		})
+	}
+}
+
+func TestStepRateLimit(t *testing.T) {
+	stubRunActions(t, func(ctx context.Context, name string, args []string, with map[string]any, verbose bool) (map[string]any, error) {
+		return map[string]any{"req": map[string]any{}, "res": map[string]any{}}, nil
+	})
+
+	var buf bytes.Buffer
+	steps := []*Step{{Uses: "http", RateLimit: 20}}
+	wf := &Workflow{Name: "Test", Jobs: []Job{{Name: "Job", Steps: steps, Repeat: &Repeat{Count: 3}}}, env: map[string]string{}}
+	start := time.Now()
+	if err := wf.Start(Config{Log: &buf}); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	// the first run is immediate, and the next two wait 50ms each
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("expected the repeats to be throttled, but took %s", elapsed)
+	}
+	throttled := 0
+	for _, j := range wf.Result().Jobs {
+		if j.Steps[0].Throttled {
+			throttled++
+		}
+	}
+	if throttled != 2 {
+		t.Errorf("expected 2 throttled runs, got %d", throttled)
+	}
+}
+
+func TestStepWaitFor(t *testing.T) {
+	tests := []struct {
+		name    string
+		waitFor *WaitFor
+		status  string
+		polls   int
+		message string
+	}{
+		{
+			name:    "condition becomes true after polls",
+			waitFor: &WaitFor{Condition: `res.status == "healthy"`, Interval: time.Millisecond, Timeout: time.Second},
+			status:  StatusNoTest,
+			polls:   3,
+		},
+		{
+			name:    "deadline passes",
+			waitFor: &WaitFor{Condition: `res.status == "never"`, Interval: 10 * time.Millisecond, Timeout: 25 * time.Millisecond},
+			status:  StatusError,
+			polls:   3,
+			message: `condition 'res.status == "never"' was not met within 25ms (3 polls)`,
+		},
+		{
+			name:    "interval defaults to 1s",
+			waitFor: &WaitFor{Condition: `res.status == "never"`, Timeout: 1500 * time.Millisecond},
+			status:  StatusError,
+			polls:   2,
+			message: `condition 'res.status == "never"' was not met within 1.5s (2 polls)`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			polls := 0
+			stubRunActions(t, func(ctx context.Context, name string, args []string, with map[string]any, verbose bool) (map[string]any, error) {
+				polls++
+				status := "starting"
+				if polls >= 3 {
+					status = "healthy"
+				}
+				return map[string]any{"req": map[string]any{}, "res": map[string]any{"status": status}}, nil
+			})
+
+			var buf bytes.Buffer
+			steps := []*Step{{Uses: "http", WaitFor: tt.waitFor}}
+			wf := &Workflow{Name: "Test", Jobs: []Job{{Name: "Job", Steps: steps}}, env: map[string]string{}}
+			if err := wf.Start(Config{Log: &buf}); err != nil {
+				t.Fatalf("unexpected error %s", err)
+			}
+
+			got := wf.Result().Jobs[0].Steps[0]
+			if got.Status != tt.status || got.Attempts != tt.polls || got.Message != tt.message {
+				t.Errorf("expected %s with %d polls (%s), got %s with %d polls (%s)", tt.status, tt.polls, tt.message, got.Status, got.Attempts, got.Message)
+			}
+		})
+	}
+}
+
+func TestJobIf(t *testing.T) {
+	stubRunActions(t, func(ctx context.Context, name string, args []string, with map[string]any, verbose bool) (map[string]any, error) {
+		return map[string]any{"req": map[string]any{}, "res": map[string]any{}}, nil
+	})
+
+	tests := []struct {
+		name    string
+		cond    string
+		steps   int
+		skipped bool
+		failed  bool
+	}{
+		{name: "true", cond: `vars.branch == "main" && env.DEPLOY == "1"`, steps: 1},
+		{name: "false", cond: `vars.branch == "develop"`, skipped: true},
+		{name: "not bool", cond: `vars.branch`, failed: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			jobs := []Job{{Name: "Deploy", If: tt.cond, Steps: []*Step{{Uses: "http"}}}}
+			wf := &Workflow{Name: "Test", Jobs: jobs, Vars: map[string]any{"branch": "main"}, env: map[string]string{"DEPLOY": "1"}}
+			if err := wf.Start(Config{Log: &buf}); err != nil {
+				t.Fatalf("unexpected error %s", err)
+			}
+
+			got := wf.Result().Jobs[0]
+			if len(got.Steps) != tt.steps || got.Skipped != tt.skipped || got.Failed != tt.failed {
+				t.Errorf("expected %d steps, skipped %t, failed %t, got %d, %t, %t", tt.steps, tt.skipped, tt.failed, len(got.Steps), got.Skipped, got.Failed)
+			}
+			if (wf.exitStatus != ExitSuccess) != tt.failed {
+				t.Errorf("unexpected exit status %d", wf.exitStatus)
+			}
+		})
+	}
+}
+
+func TestJobRepeatUntil(t *testing.T) {
+	tests := []struct {
+		name       string
+		repeat     *Repeat
+		iterations int
+		stoppedBy  string
+		failed     bool
+	}{
+		{name: "until passed", repeat: &Repeat{Until: "steps[0].res.code == 200", MaxCount: 5}, iterations: 3, stoppedBy: StoppedByUntil},
+		{name: "max count reached", repeat: &Repeat{Until: "steps[0].res.code == 200", MaxCount: 2}, iterations: 2, stoppedBy: StoppedByMaxCount, failed: true},
+		{name: "count as max count", repeat: &Repeat{Count: 1, Until: "steps[0].res.code == 200"}, iterations: 1, stoppedBy: StoppedByMaxCount, failed: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int32
+			stubRunActions(t, func(ctx context.Context, name string, args []string, with map[string]any, verbose bool) (map[string]any, error) {
+				code := 503
+				if atomic.AddInt32(&calls, 1) >= 3 {
+					code = 200
+				}
+				return map[string]any{"req": map[string]any{}, "res": map[string]any{"code": code}}, nil
+			})
+
+			var buf bytes.Buffer
+			jobs := []Job{{Name: "Poll", Repeat: tt.repeat, Steps: []*Step{{Uses: "http", Test: "res.code == 200"}}}}
+			wf := &Workflow{Name: "Test", Jobs: jobs, env: map[string]string{}}
+			if err := wf.Start(Config{Log: &buf}); err != nil {
+				t.Fatalf("unexpected error %s", err)
+			}
+
+			if len(wf.Result().Jobs) != 1 {
+				t.Fatalf("expected only the last run to be recorded, got %d", len(wf.Result().Jobs))
+			}
+			got := wf.Result().Jobs[0]
+			if got.Iterations != tt.iterations || got.StoppedBy != tt.stoppedBy || got.Failed != tt.failed {
+				t.Errorf("expected %d iterations stopped by %s (failed %t), got %d stopped by %s (failed %t)",
+					tt.iterations, tt.stoppedBy, tt.failed, got.Iterations, got.StoppedBy, got.Failed)
+			}
+			if (wf.exitStatus != ExitSuccess) != tt.failed {
+				t.Errorf("unexpected exit status %d", wf.exitStatus)
+			}
+		})
+	}
+}
+
+func TestJobRepeatUntilWithoutMaxCount(t *testing.T) {
+	jobs := []Job{{Name: "Poll", Repeat: &Repeat{Until: "true"}, Steps: []*Step{{Uses: "http"}}}}
+	wf := &Workflow{Name: "Test", Jobs: jobs, env: map[string]string{}}
+	err := wf.Start(Config{Log: &bytes.Buffer{}})
+
+	var ce *ConfigError
+	if !errors.As(err, &ce) || err.Error() != "jobs[0]: repeat with until requires max_count" {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestRepeatIntervalJitter(t *testing.T) {
+	r := &Repeat{Interval: 10, Jitter: 20, Seed: 42}
+	min, max := 8*time.Second, 12*time.Second
+
+	next := r.intervalFunc()
+	var got []time.Duration
+	for i := 0; i < 100; i++ {
+		d := next()
+		if d < min || d > max {
+			t.Fatalf("expected interval in %s..%s, got %s", min, max, d)
+		}
+		got = append(got, d)
+	}
+
+	// the same seed gives the same intervals
+	next = r.intervalFunc()
+	for i, expected := range got {
+		if d := next(); d != expected {
+			t.Fatalf("interval %d: expected %s, got %s", i, expected, d)
+		}
+	}
+
+	// no jitter
+	next = (&Repeat{Interval: 3}).intervalFunc()
+	if d := next(); d != 3*time.Second {
+		t.Errorf("expected 3s, got %s", d)
+	}
+}
+
+func TestMaxConcurrency(t *testing.T) {
+	var running, peak int32
+	stubRunActions(t, func(ctx context.Context, name string, args []string, with map[string]any, verbose bool) (map[string]any, error) {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		return map[string]any{"req": map[string]any{}, "res": map[string]any{}}, nil
+	})
+
+	jobs := []Job{}
+	for i := 0; i < 6; i++ {
+		jobs = append(jobs, Job{Name: fmt.Sprintf("Job %d", i), Steps: []*Step{{Uses: "hello"}}})
+	}
+	wf := &Workflow{Name: "Test", Jobs: jobs, env: map[string]string{}}
+
+	var buf bytes.Buffer
+	if err := wf.Start(Config{Log: &buf, MaxConcurrency: 2}); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if peak > 2 {
+		t.Errorf("expected at most 2 jobs running, got %d", peak)
+	}
+	if len(wf.Result().Jobs) != 6 {
+		t.Errorf("expected 6 job results, got %d", len(wf.Result().Jobs))
+	}
+}
+
+func TestStepDump(t *testing.T) {
+	stubRunActions(t, func(ctx context.Context, name string, args []string, with map[string]any, verbose bool) (map[string]any, error) {
+		return map[string]any{"req": with, "res": map[string]any{"code": 200, "body": "ok"}}, nil
+	})
+
+	dir := filepath.Join(t.TempDir(), "dump")
+	steps := []*Step{{Name: "Get users", Uses: "http", With: map[string]any{"token": "{vars.api_token}"}}}
+	wf := &Workflow{
+		Name: "Test",
+		Jobs: []Job{{Name: "API", Steps: steps}},
+		Vars: map[string]any{"api_token": "s3cr3t-value"},
+		env:  map[string]string{},
+	}
+	if err := wf.Start(Config{Log: &bytes.Buffer{}, DumpDir: dir}); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "API-0-Get_users-*.json"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("expected one dump file, got %v (%v)", files, err)
+	}
+	b, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "s3cr3t-value") {
+		t.Errorf("expected the secret to be masked:\n%s", b)
+	}
+	var dump map[string]any
+	if err := json.Unmarshal(b, &dump); err != nil {
+		t.Fatalf("invalid dump %s: %s", b, err)
+	}
+	if dump["step"] != "Get users" || dump["req"].(map[string]any)["token"] != "[REDACTED]" {
+		t.Errorf("unexpected dump %s", b)
+	}
+}
+
+func TestCompact(t *testing.T) {
+	stubRunActions(t, func(ctx context.Context, name string, args []string, with map[string]any, verbose bool) (map[string]any, error) {
+		return map[string]any{"req": map[string]any{}, "res": map[string]any{"code": 500}}, nil
+	})
+
+	steps := []*Step{
+		{Name: "Get users", Uses: "http", Test: "res.code == 200", Echo: "res.code"},
+		{Name: "Get posts", Uses: "http", Test: "res.code == 500"},
+		{Name: "Get tags", Uses: "http", Test: "res.code == 200", ContinueOnError: true},
+	}
+	wf := &Workflow{Name: "Test", Jobs: []Job{{Name: "Job", Steps: steps}}, env: map[string]string{}}
+
+	var buf bytes.Buffer
+	if err := wf.Start(Config{Log: &buf, Compact: true, Verbose: true}); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	expected := []*regexp.Regexp{
+		regexp.MustCompile(`^Job$`),
+		regexp.MustCompile(`^ 0\. ✘  Get users \(\d+(\.\d+)?[µnm]?s\)$`),
+		regexp.MustCompile(`^ 1\. ✔︎  Get posts \(\d+(\.\d+)?[µnm]?s\)$`),
+		regexp.MustCompile(`^ 2\. ⚠  Get tags \(\d+(\.\d+)?[µnm]?s\)$`),
+		regexp.MustCompile(`^$`),
+		regexp.MustCompile(`^3 steps: 1 passed, 1 failed, 1 warning \(\d+(\.\d+)?[µnm]?s\)$`),
+	}
+	if len(lines) != len(expected) {
+		t.Fatalf("expected %d lines, got:\n%s", len(expected), buf.String())
+	}
+	for i, re := range expected {
+		if !re.MatchString(lines[i]) {
+			t.Errorf("line %d: expected %s, got %q", i, re, lines[i])
+		}
+	}
+}
+
+func TestFailFast(t *testing.T) {
+	stubRunActions(t, func(ctx context.Context, name string, args []string, with map[string]any, verbose bool) (map[string]any, error) {
+		switch name {
+		case "slow":
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(5 * time.Second):
+			}
+		case "fail":
+			// the slow job is running when this fails
+			time.Sleep(100 * time.Millisecond)
+		}
+		return map[string]any{"req": map[string]any{}, "res": map[string]any{"code": 500}}, nil
+	})
+
+	wf := &Workflow{
+		Name: "Test",
+		Jobs: []Job{
+			{Name: "Fail", Steps: []*Step{{Name: "Get", Uses: "fail", Test: "res.code == 200"}}},
+			{Name: "Slow", Steps: []*Step{{Name: "Wait", Uses: "slow"}, {Name: "Next", Uses: "http"}}},
+			{Name: "Repeated", Steps: []*Step{{Name: "Get", Uses: "http"}}, Repeat: &Repeat{Count: 3, Interval: 1}},
+		},
+		env: map[string]string{},
+	}
+	start := time.Now()
+	if err := wf.Start(Config{Log: &bytes.Buffer{}, FailFast: true}); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("expected the slow job to be aborted, but took %s", elapsed)
+	}
+	if wf.exitStatus != ExitTestFailure {
+		t.Errorf("expected exit status %d, got %d", ExitTestFailure, wf.exitStatus)
+	}
+
+	statuses := map[string][]string{}
+	for _, j := range wf.Result().Jobs {
+		statuses[j.Name] = append(statuses[j.Name], j.Status())
+		if j.Name == "Slow" && (len(j.Steps) != 1 || j.Steps[0].Status != StatusAborted) {
+			t.Errorf("expected only the running step to be recorded as aborted, got %+v", j.Steps)
+		}
+	}
+	expected := map[string][]string{
+		"Fail":     {StatusFailure},
+		"Slow":     {StatusAborted},
+		"Repeated": {StatusSuccess},
+	}
+	if !reflect.DeepEqual(statuses, expected) {
+		t.Errorf("expected %v, got %v", expected, statuses)
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+	stubRunActions(t, func(ctx context.Context, name string, args []string, with map[string]any, verbose bool) (map[string]any, error) {
+		if name == "sleep" {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(5 * time.Second):
+			}
+		}
+		return map[string]any{"req": map[string]any{}, "res": map[string]any{"code": 200}}, nil
+	})
+
+	wf := &Workflow{
+		Name: "Test",
+		Jobs: []Job{
+			{Name: "Fast", Steps: []*Step{{Name: "Get", Uses: "http", Test: "res.code == 200"}}},
+			{Name: "Sleep", Steps: []*Step{{Name: "Wait", Uses: "sleep"}, {Name: "Next", Uses: "http"}}},
+		},
+		env: map[string]string{},
+	}
+	var buf bytes.Buffer
+	start := time.Now()
+	if err := wf.Start(Config{Log: &buf, Timeout: 200 * time.Millisecond}); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("expected the sleeping step to be canceled, but took %s", elapsed)
+	}
+	if wf.exitStatus != ExitTimeout {
+		t.Errorf("expected exit status %d, got %d", ExitTimeout, wf.exitStatus)
+	}
+
+	statuses := map[string]string{}
+	for _, j := range wf.Result().Jobs {
+		statuses[j.Name] = j.Status()
+		if j.Name == "Sleep" && (len(j.Steps) != 1 || j.Steps[0].Message != "aborted: the run timed out") {
+			t.Errorf("expected the running step to be aborted by the timeout, got %+v", j.Steps)
+		}
+	}
+	expected := map[string]string{"Fast": StatusSuccess, "Sleep": StatusAborted}
+	if !reflect.DeepEqual(statuses, expected) {
+		t.Errorf("expected %v, got %v", expected, statuses)
+	}
+	if !strings.Contains(buf.String(), "timeout: the run exceeded 200ms") {
+		t.Errorf("unexpected output %q", buf.String())
+	}
+}
+
+func TestRerunFailed(t *testing.T) {
+	var ran []string
+	var mu sync.Mutex
+	stubRunActions(t, func(ctx context.Context, name string, args []string, with map[string]any, verbose bool) (map[string]any, error) {
+		mu.Lock()
+		ran = append(ran, with["job"].(string))
+		mu.Unlock()
+		return map[string]any{"req": map[string]any{}, "res": map[string]any{"code": 200}}, nil
+	})
+
+	prev := &Result{Name: "Test", Jobs: []*JobResult{
+		{Name: "Passed"},
+		{Name: "Failed", Failed: true},
+		{Name: "Aborted", Aborted: true},
+	}}
+	path := filepath.Join(t.TempDir(), "result.json")
+	var b bytes.Buffer
+	if err := prev.WriteJSON(&b); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, b.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	step := func(job string) []*Step {
+		return []*Step{{Uses: "http", With: map[string]any{"job": job}, Test: "res.code == 200"}}
+	}
+	wf := &Workflow{
+		Name: "Test",
+		Jobs: []Job{
+			{Name: "Passed", Steps: step("Passed")},
+			{Name: "Failed", Steps: step("Failed")},
+			{Name: "Aborted", Steps: step("Aborted")},
+		},
+		BeforeAll: step(BeforeAllJob),
+		env:       map[string]string{},
+	}
+	if err := wf.Start(Config{Log: &bytes.Buffer{}, RerunFailed: path}); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	sort.Strings(ran)
+	if expected := []string{"Aborted", "Failed", BeforeAllJob}; !reflect.DeepEqual(ran, expected) {
+		t.Errorf("expected %v to run, got %v", expected, ran)
+	}
+	statuses := map[string]string{}
+	for _, j := range wf.Result().Jobs {
+		statuses[j.Name] = j.Status()
+	}
+	expected := map[string]string{BeforeAllJob: StatusSuccess, "Passed": StatusSkipped, "Failed": StatusSuccess, "Aborted": StatusSuccess}
+	if !reflect.DeepEqual(statuses, expected) {
+		t.Errorf("expected %v, got %v", expected, statuses)
+	}
+
+	err := wf.Start(Config{Log: &bytes.Buffer{}, RerunFailed: filepath.Join(t.TempDir(), "missing.json")})
+	var ce *ConfigError
+	if !errors.As(err, &ce) {
+		t.Errorf("expected ConfigError, got %#v", err)
+	}
+}
+
+func TestJobAborted(t *testing.T) {
+	stubRunActions(t, func(ctx context.Context, name string, args []string, with map[string]any, verbose bool) (map[string]any, error) {
+		t.Errorf("expected no action to run")
+		return nil, nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var buf bytes.Buffer
+	job := &Job{Name: "Queued", Steps: []*Step{{Name: "Get", Uses: "http"}}}
+	if failed := job.Start(JobContext{Config: Config{Log: &buf}, spanCtx: ctx}); failed {
+		t.Error("expected the aborted job not to fail")
+	}
+	if !job.ctx.Result.Aborted || job.ctx.Result.Status() != StatusAborted || len(job.ctx.Result.Steps) != 0 {
+		t.Errorf("expected the job aborted before steps, got %+v", job.ctx.Result)
+	}
+	if !strings.Contains(buf.String(), "aborted: another job failed") {
+		t.Errorf("unexpected output %q", buf.String())
+	}
+}
+
+func TestBeforeAllAfterAll(t *testing.T) {
+	tests := []struct {
+		name      string
+		setupCode int
+		calls     []string
+		jobs      []string
+	}{
+		{name: "jobs fail", setupCode: 200, calls: []string{"setup", "job", "teardown"}, jobs: []string{"before_all", "Job", "after_all"}},
+		{name: "before_all fails", setupCode: 500, calls: []string{"setup", "teardown"}, jobs: []string{"before_all", "after_all"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var calls []string
+			stubRunActions(t, func(ctx context.Context, name string, args []string, with map[string]any, verbose bool) (map[string]any, error) {
+				mu.Lock()
+				calls = append(calls, with["call"].(string))
+				mu.Unlock()
+				code := 500
+				if with["call"] == "setup" {
+					code = tt.setupCode
+				}
+				return map[string]any{"req": with, "res": map[string]any{"code": code}}, nil
+			})
+
+			wf := &Workflow{
+				Name:      "Test",
+				BeforeAll: []*Step{{Name: "Start", Uses: "http", With: map[string]any{"call": "setup"}, Test: "res.code == 200"}},
+				Jobs:      []Job{{Name: "Job", Steps: []*Step{{Uses: "http", With: map[string]any{"call": "job"}, Test: "res.code == 200"}}}},
+				AfterAll:  []*Step{{Name: "Stop", Uses: "http", With: map[string]any{"call": "teardown"}}},
+				env:       map[string]string{},
+			}
+			if err := wf.Start(Config{Log: &bytes.Buffer{}, FailFast: true}); err != nil {
+				t.Fatalf("unexpected error %s", err)
+			}
+
+			if !reflect.DeepEqual(calls, tt.calls) {
+				t.Errorf("expected calls %v, got %v", tt.calls, calls)
+			}
+			var jobs []string
+			for _, j := range wf.Result().Jobs {
+				jobs = append(jobs, j.Name)
+			}
+			if !reflect.DeepEqual(jobs, tt.jobs) {
+				t.Errorf("expected job results %v, got %v", tt.jobs, jobs)
+			}
+			if wf.exitStatus != ExitTestFailure {
+				t.Errorf("expected exit status %d, got %d", ExitTestFailure, wf.exitStatus)
+			}
+		})
+	}
+}